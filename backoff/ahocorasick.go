@@ -0,0 +1,106 @@
+/*
+* Copyright 2020-present, Synopsys, Inc. * All rights reserved.
+*
+* This source code is licensed under the Apache-2.0 license found in
+* the LICENSE file in the root directory of this source tree. */
+package backoff
+
+// ahoCorasick finds which of a set of strings a text contains in a single
+// pass over the text, however many strings there are
+type ahoCorasick struct {
+	// next[state*256+b] is the state after reading b in state
+	next []int32
+	// found[state] are the patterns that end at state
+	found [][]int
+	// Patterns found before reading anything, which is only the empty one
+	empty    []int
+	patterns int
+}
+
+func newAhoCorasick(patterns []string) *ahoCorasick {
+	ac := &ahoCorasick{patterns: len(patterns)}
+	// The trie, with -1 for no edge
+	var trie [][256]int32
+	var found [][]int
+	newState := func() int32 {
+		var edges [256]int32
+		for b := range edges {
+			edges[b] = -1
+		}
+		trie = append(trie, edges)
+		found = append(found, nil)
+		return int32(len(trie) - 1)
+	}
+	newState()
+	for p, pattern := range patterns {
+		if pattern == "" {
+			ac.empty = append(ac.empty, p)
+			continue
+		}
+		state := int32(0)
+		for i := 0; i < len(pattern); i++ {
+			b := pattern[i]
+			if trie[state][b] < 0 {
+				// newState may move the trie, so it is called first
+				child := newState()
+				trie[state][b] = child
+			}
+			state = trie[state][b]
+		}
+		found[state] = append(found[state], p)
+	}
+
+	// Fill in the missing edges breadth first, following the longest
+	// suffix that is also in the trie, so no state ever has to back up
+	ac.next = make([]int32, len(trie)*256)
+	fail := make([]int32, len(trie))
+	var queue []int32
+	for b := 0; b < 256; b++ {
+		child := trie[0][b]
+		if child < 0 {
+			ac.next[b] = 0
+			continue
+		}
+		ac.next[b] = child
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		found[state] = append(found[state], found[fail[state]]...)
+		for b := 0; b < 256; b++ {
+			child := trie[state][b]
+			if child < 0 {
+				ac.next[int(state)*256+b] = ac.next[int(fail[state])*256+b]
+				continue
+			}
+			fail[child] = ac.next[int(fail[state])*256+b]
+			ac.next[int(state)*256+b] = child
+			queue = append(queue, child)
+		}
+	}
+	ac.found = found
+	return ac
+}
+
+// contains reports which of the patterns text contains, indexed as they
+// were given to newAhoCorasick
+func (ac *ahoCorasick) contains(text string) []bool {
+	contained := make([]bool, ac.patterns)
+	remaining := ac.patterns
+	for _, p := range ac.empty {
+		contained[p] = true
+		remaining--
+	}
+	state := int32(0)
+	for i := 0; i < len(text) && remaining > 0; i++ {
+		state = ac.next[int(state)*256+int(text[i])]
+		for _, p := range ac.found[state] {
+			if !contained[p] {
+				contained[p] = true
+				remaining--
+			}
+		}
+	}
+	return contained
+}
@@ -0,0 +1,468 @@
+/*
+* Copyright 2020-present, Synopsys, Inc. * All rights reserved.
+*
+* This source code is licensed under the Apache-2.0 license found in
+* the LICENSE file in the root directory of this source tree. */
+
+// Package backoff runs a command, retrying it with a configurable backoff
+// based off of either its exit code or its output. It is what the eb
+// command line is built on, and can be used directly from Go code.
+package backoff
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	logging "github.com/op/go-logging"
+)
+
+// log has a backend of its own that discards everything, so a program using
+// the package does not have its stderr filled with the loop's logs. eb
+// passes its own backend to SetLogBackend.
+var log = newLogger()
+
+func newLogger() *logging.Logger {
+	logger := logging.MustGetLogger("backoff")
+	logger.SetBackend(logging.AddModuleLevel(logging.NewLogBackend(io.Discard, "", 0)))
+	return logger
+}
+
+// SetLogBackend has the package log to backend, at the levels it allows.
+// Nothing is logged until it is called.
+func SetLogBackend(backend logging.LeveledBackend) {
+	log.SetBackend(backend)
+}
+
+// Run runs command, retrying it as described by policy, and returns a
+// Result describing every attempt and why the loop stopped. An empty
+// command is not run, and gives a *CommandError with InternalErrorExitCode.
+//
+// Cancelling ctx interrupts the wait between attempts and stops a running
+// command, giving it Policy.KillGracePeriod to exit before it is killed,
+// or until the kill function of a ctx from WithKill is called. Every
+// attempt, and the perform on failure and perform on exit commands, are
+// given EB_ environment variables describing the run (see loopEnv).
+//
+// Policy.AttemptTimeout stops a single attempt the same way. Each attempt
+// runs in its own process group, so anything the command started is
+// stopped along with it. When eb is in the foreground of a terminal, the
+// group is given the foreground while the attempt runs, so the command can
+// read from the terminal, and the command being interrupted by Ctrl-C
+// stops the run as if eb had received SIGINT. Cancelling ctx with a
+// *SignalError as the cause sends that signal to the command instead of
+// SIGTERM, and sets the exit code to 128 plus the signal.
+func Run(ctx context.Context, policy Policy, command []string) Result {
+	result := run(ctx, policy, command)
+	var signalErr *SignalError
+	if result.Reason == Cancelled && errors.As(context.Cause(ctx), &signalErr) {
+		result.ExitCode = signalErr.ExitCode()
+		result.Err = signalErr
+	}
+	if policy.PerformOnExit != "" {
+		if err := catchFailure("Exit", policy.PerformOnExit, resultEnv(policy, result)); err != nil {
+			log.Error(err)
+			if result.Err == nil {
+				result.Err = err
+			}
+		}
+	}
+	return result
+}
+
+func run(ctx context.Context, policy Policy, command []string) Result {
+	log.Info("-------- Settings -------")
+	log.Info("Expression               : ", policy.Expression)
+	log.Info("Strategy                 : ", policy.Strategy)
+	log.Info("Retry After Regexps      : ", policy.RetryAfterRegexps)
+	log.Info("Retry After Overrides    : ", policy.RetryAfterOverrides)
+	log.Info("Rules                    : ", len(policy.Rules))
+	log.Info("Explain                  : ", policy.Explain)
+	log.Info("Match Window             : ", policy.MatchWindow)
+	log.Info("Retries                  : ", policy.Retries)
+	log.Info("Duration                 : ", policy.Duration)
+	log.Info("Retry On All             : ", policy.RetryOnAll)
+	log.Info("Retry On Exit Codes      : ", policy.RetryOnExitCodes)
+	log.Info("Retry On Signals         : ", policy.RetryOnSignals)
+	log.Info("Retry On String Matches  : ", policy.RetryOnStrings)
+	log.Info("Retry On Regexp Matches  : ", policy.RetryOnRegexps)
+	log.Info("Retry On JSON Matches    : ", policy.RetryOnJSON)
+	log.Info("Retry If                 : ", policy.RetryIf)
+	log.Info("Success On Exit Codes    : ", policy.SuccessOnExitCodes)
+	log.Info("Success On String Matches: ", policy.SuccessOnStrings)
+	log.Info("Success On Regexp Matches: ", policy.SuccessOnRegexps)
+	log.Info("Success On JSON Matches  : ", policy.SuccessOnJSON)
+	log.Info("Succeed If               : ", policy.SucceedIf)
+	log.Info("Perform On Failure       : ", policy.PerformOnFailure)
+	log.Info("Perform On Exit          : ", policy.PerformOnExit)
+	log.Info("Fail On String Matches: ", policy.FailOnStrings)
+	log.Info("Fail On Regexp Matches: ", policy.FailOnRegexps)
+	log.Info("Fail On JSON Matches: ", policy.FailOnJSON)
+	log.Info("Fail If: ", policy.FailIf)
+	log.Info("Fail Unless String Matches: ", policy.FailUnlessStrings)
+	log.Info("Fail Unless Regexp Matches: ", policy.FailUnlessRegexps)
+	log.Info("Print Retry On Failure: ", policy.PrintRetryOnFailure)
+	log.Info("Print Verbose Retry On Failure: ", policy.PrintVerboseRetryOnFailure)
+	log.Info("Metrics Enabled: ", policy.MetricsEnabled)
+	log.Info("Attempt Timeout: ", policy.AttemptTimeout)
+	log.Info("Retry On Timeout: ", policy.RetryOnTimeout)
+	log.Info("Kill Grace Period: ", policy.KillGracePeriod)
+	log.Info("Duration Stops Attempt: ", policy.DurationStopsAttempt)
+	log.Info("Output Mode: ", policy.OutputMode)
+	log.Info("Prefix Output: ", policy.PrefixOutput)
+	log.Info("Passthrough Stdin: ", policy.PassthroughStdin)
+	log.Info("Command to Run           : ", command)
+	log.Info("-------------------------")
+
+	// metric logging is turned off for the rest of the run if it fails
+	metricsEnabled := policy.MetricsEnabled
+
+	result := Result{RunID: newRunID()}
+
+	if len(command) == 0 {
+		result.ExitCode = InternalErrorExitCode
+		result.Reason = Failed
+		result.Err = &CommandError{Err: errors.New("no command given")}
+		return result
+	}
+
+	random := policy.random()
+	formula, err := compileFormula(policy, random)
+	if err != nil {
+		result.ExitCode = InternalErrorExitCode
+		result.Reason = ExpressionError
+		result.Err = err
+		return result
+	}
+	allRules := policy.AllRules()
+	rules, err := compileRules(policy, allRules, random)
+	if err != nil {
+		result.ExitCode = InternalErrorExitCode
+		result.Reason = ExpressionError
+		result.Err = err
+		return result
+	}
+	matchers, err := newMatcherSet(allRules)
+	if err != nil {
+		result.ExitCode = InternalErrorExitCode
+		result.Reason = ExpressionError
+		result.Err = err
+		return result
+	}
+	explainRules(policy, allRules)
+
+	passthrough := policy.PassthroughStdin && policy.Stdin != nil
+	if passthrough && policy.Retries != 0 {
+		log.Warning("Stdin can only be passed through when retries are disabled. Replaying it instead.")
+		passthrough = false
+	}
+	var stdin *spool
+	if policy.Stdin != nil && !passthrough {
+		stdin = newSpool(policy.Stdin, policy.StdinMemoryLimit)
+		defer stdin.Close()
+	}
+	clock := policy.clock()
+	xIncrement := 0
+	start := clock.Now()
+	for {
+		if ctx.Err() != nil {
+			log.Warning("Cancelled before running command:", command)
+			if len(result.Attempts) == 0 {
+				// Exit as an attempt stopped by ctx would have
+				result.ExitCode = 128 + int(stopSignal(ctx, policy))
+			}
+			result.Reason = Cancelled
+			return result
+		}
+
+		metricStart := clock.Now()
+
+		log.Debug("Running:", command[0])
+		log.Debug("Params:", command[1:])
+		// The attempt is stopped by whichever comes first of ctx, the end of
+		// the duration (when asked for), and the attempt timeout
+		durationCtx, cancelDuration := ctx, context.CancelFunc(func() {})
+		if policy.DurationStopsAttempt && policy.Duration >= 0 {
+			durationCtx, cancelDuration = context.WithTimeout(ctx, policy.Duration-clock.Now().Sub(start))
+		}
+		attemptCtx, cancelAttempt := durationCtx, context.CancelFunc(func() {})
+		if policy.AttemptTimeout > 0 {
+			attemptCtx, cancelAttempt = context.WithTimeout(durationCtx, policy.AttemptTimeout)
+		}
+		cmd := exec.CommandContext(attemptCtx, command[0], command[1:]...)
+		var out bytes.Buffer
+		var stderr bytes.Buffer
+		cmd.Stdout, cmd.Stderr = attemptWriters(policy, xIncrement+1, &out, &stderr)
+		cmd.Env = loopEnv(policy, result.RunID, start, xIncrement+1, previousExitCode(result))
+		if passthrough {
+			cmd.Stdin = policy.Stdin
+		}
+		restoreForeground, foreground := startInProcessGroup(cmd)
+		stopFeeding := func() {}
+		if stdin != nil {
+			pipe, stop, err := stdin.feed(attemptCtx)
+			if err != nil {
+				cancelAttempt()
+				cancelDuration()
+				result.ExitCode = 1
+				result.Reason = Failed
+				result.Err = fmt.Errorf("unable to give the command stdin: %w", err)
+				return result
+			}
+			cmd.Stdin, stopFeeding = pipe, stop
+		}
+		exited := make(chan struct{})
+		cmd.Cancel = func() error {
+			go killOnRequest(ctx, cmd, exited)
+			return terminate(cmd, stopSignal(attemptCtx, policy))
+		}
+		cmd.WaitDelay = policy.KillGracePeriod
+		runErr := cmd.Run()
+		restoreForeground()
+		close(exited)
+		stopFeeding()
+		// Only the final attempt failing to start is reported
+		result.Err = nil
+		if cmd.ProcessState == nil {
+			log.Warning("Unable to start command: ", runErr)
+			result.Err = fmt.Errorf("unable to start %s: %w", command[0], runErr)
+		}
+		exitCode, signal := exitStatus(cmd.ProcessState)
+		if signal != 0 {
+			log.Debug("Command was killed by ", SignalName(signal))
+		}
+		log.Debug("Command exitted with ", exitCode)
+		if attemptCtx.Err() != nil {
+			// Make sure nothing the stopped attempt started is still
+			// running when the next attempt starts
+			killProcessGroup(cmd)
+		}
+		timedOut := durationCtx.Err() == nil && attemptCtx.Err() != nil
+		durationExpired := ctx.Err() == nil && durationCtx.Err() != nil
+		cancelAttempt()
+		cancelDuration()
+
+		metricEnd := clock.Now()
+		metricElapsed := metricEnd.Sub(metricStart)
+		result.Attempts = append(result.Attempts, Attempt{
+			Number:   xIncrement + 1,
+			Start:    metricStart,
+			Elapsed:  metricElapsed,
+			ExitCode: exitCode,
+			Signal:   signal,
+			TimedOut: timedOut || durationExpired,
+		})
+		result.Stdout = out.String()
+		result.Stderr = stderr.String()
+
+		if metricsEnabled {
+			err := logMetric(metricStart.String(), metricEnd.String(), fmt.Sprintf("%f", metricElapsed.Seconds()), command[0], strings.Join(command[1:], " "), strconv.Itoa(exitCode), out.String(), stderr.String())
+			if err != nil {
+				log.Error("Unable To Log Metrics!")
+				log.Error(err)
+				metricsEnabled = false
+			}
+		}
+
+		// The command may have been stopped part way through, so its output
+		// is not worth classifying
+		if ctx.Err() != nil {
+			log.Warning("Cancelled while running command:", command)
+			showOutput(policy, out.String(), stderr.String())
+			result.ExitCode = exitCode
+			result.Reason = Cancelled
+			return result
+		}
+
+		// Ctrl-C at the terminal only reaches the command's group while it
+		// has the foreground, so the command being interrupted stops eb, as
+		// it would a shell
+		if foreground && (signal == syscall.SIGINT || signal == syscall.SIGQUIT) {
+			log.Warning("Command was interrupted at the terminal:", command)
+			showOutput(policy, out.String(), stderr.String())
+			result.ExitCode = exitCode
+			result.Reason = Cancelled
+			result.Err = &SignalError{Signal: signal}
+			return result
+		}
+
+		if durationExpired {
+			log.Warning("Failed to complete command due to maximum runtime exhausted:", command)
+			showOutput(policy, out.String(), stderr.String())
+			result.ExitCode = TimeoutExitCode
+			result.Rule = "duration"
+			result.Reason = DurationExhausted
+			return result
+		}
+
+		var needToExit, failOnMatch, succeeded, forced bool
+		var rule string
+		matched := -1
+		if timedOut {
+			// The output of a hung command is incomplete, so only the
+			// timeout settings decide what happens next
+			log.Warning("Command timed out after", policy.AttemptTimeout)
+			exitCode = TimeoutExitCode
+			rule = "attempt_timeout"
+			needToExit = !policy.RetryOnTimeout && !policy.RetryOnAll
+			if needToExit {
+				explainf(policy, "Attempt %d: %s -> %s\n", xIncrement+1, rule, Fail)
+			} else {
+				explainf(policy, "Attempt %d: %s -> %s\n", xIncrement+1, rule, Retry)
+			}
+		} else {
+			o := commandOutcome(exitCode, signal, out.String(), stderr.String())
+			o.elapsed = metricElapsed
+			v := classify(policy, allRules, matchers, xIncrement+1, o)
+			if v.err != nil {
+				log.Error(v.err)
+				showOutput(policy, out.String(), stderr.String())
+				result.ExitCode = InternalErrorExitCode
+				result.Rule = v.description
+				result.Reason = ExpressionError
+				result.Err = v.err
+				return result
+			}
+			matched, rule = v.rule, v.description
+			failOnMatch = v.forced || (v.action == Fail && matched >= 0)
+			succeeded, forced = v.action == Succeed, v.forced
+			switch v.action {
+			case Succeed, Fail:
+				needToExit = true
+			}
+		}
+		// exitCode stays the command's own, for the expression. A fail rule
+		// keeps it for eb to exit with, unless it was 0. fail_on and the
+		// other settings always force one.
+		result.ExitCode = exitCode
+		if succeeded {
+			result.ExitCode = 0
+		} else if forced || (failOnMatch && exitCode == 0) {
+			result.ExitCode = ForcedFailureExitCode
+		}
+		result.Rule = rule
+
+		if needToExit {
+			log.Debug("Exiting with ", result.ExitCode)
+			showOutput(policy, out.String(), stderr.String())
+			if timedOut {
+				result.Reason = TimedOut
+			} else if result.ExitCode == 0 {
+				result.Reason = Succeeded
+			} else if failOnMatch {
+				result.Reason = FailOnMatch
+			} else {
+				result.Reason = Failed
+			}
+			return result
+		}
+
+		log.Info(stderr.String())
+		log.Info(out.String())
+
+		xIncrement++
+		if reason, done := exhausted(policy, xIncrement, clock.Now().Sub(start)); done {
+			if reason == RetriesExhausted {
+				log.Warning("Failed to complete command due to retries exhausted:", command)
+			} else {
+				log.Warning("Failed to complete command due to maximum runtime exhausted:", command)
+			}
+			log.Warning("Exitting with error code:", result.ExitCode)
+			showOutput(policy, out.String(), stderr.String())
+			result.Reason = reason
+			return result
+		}
+
+		ruleFormula, ruleIncrement, ok := rules.retry(allRules, matched, formula, xIncrement)
+		if !ok {
+			log.Warning("Failed to complete command due to retries exhausted:", command)
+			showOutput(policy, out.String(), stderr.String())
+			result.Reason = RetriesExhausted
+			return result
+		}
+
+		log.Info("Program exitted with exit code: ", exitCode)
+		state := newLoopState(clock.Now().Sub(start), ruleIncrement, result.Attempts, exitCode)
+		state.hint, state.hinted = retryAfter(policy, clock.Now(), policy.MatchWindow.tail(out.String()), policy.MatchWindow.tail(stderr.String()))
+		sleepForD, err := ruleFormula.nextSleep(policy, state)
+		if err != nil {
+			result.ExitCode = InternalErrorExitCode
+			result.Reason = ExpressionError
+			result.Err = err
+			return result
+		}
+
+		if policy.PrintRetryOnFailure || policy.PrintVerboseRetryOnFailure {
+			if policy.PrintVerboseRetryOnFailure {
+				showOutput(policy, out.String(), stderr.String())
+			}
+			fmt.Fprintln(policy.stdout(), "Next Retry Attempt", xIncrement, "in", sleepForD, "...")
+		}
+		result.Attempts[len(result.Attempts)-1].Sleep = sleepForD
+		if !clock.Sleep(ctx, sleepForD) {
+			log.Warning("Cancelled while waiting to retry command:", command)
+			result.Reason = Cancelled
+			return result
+		}
+		// The wait was cut short at the end of the duration, which would
+		// stop the next attempt before it could start
+		if policy.DurationStopsAttempt && policy.Duration >= 0 && clock.Now().Sub(start) >= policy.Duration {
+			log.Warning("Failed to complete command due to maximum runtime exhausted:", command)
+			showOutput(policy, out.String(), stderr.String())
+			result.Reason = DurationExhausted
+			return result
+		}
+
+		if policy.PerformOnFailure != "" {
+			if err := catchFailure("Failure", policy.PerformOnFailure, loopEnv(policy, result.RunID, start, xIncrement, previousExitCode(result))); err != nil {
+				log.Error(err)
+				result.Reason = HookFailed
+				result.Err = err
+				return result
+			}
+		}
+	}
+}
+
+// previousExitCode is the exit code the last attempt returned, if there has
+// been one
+func previousExitCode(result Result) string {
+	if len(result.Attempts) == 0 {
+		return ""
+	}
+	return strconv.Itoa(result.Attempts[len(result.Attempts)-1].ExitCode)
+}
+
+func catchFailure(typeOfCatch string, command string, env []string) error {
+	errorCommand, err := ParseCommand(command)
+	if err != nil {
+		return &HookError{Hook: typeOfCatch, Command: command, Err: err}
+	}
+
+	log.Debug("Running:", errorCommand[0])
+	log.Debug("Params:", errorCommand[1:])
+	ecmd := exec.Command(errorCommand[0], errorCommand[1:]...)
+	ecmd.Env = env
+	var eout bytes.Buffer
+	var estderr bytes.Buffer
+	ecmd.Stdout = &eout
+	ecmd.Stderr = &estderr
+	err = ecmd.Run()
+	eexitCode := ecmd.ProcessState.ExitCode()
+	log.Debug("Command exited with ", eexitCode)
+	log.Info(estderr.String())
+	log.Info(eout.String())
+	if ecmd.ProcessState == nil {
+		return &HookError{Hook: typeOfCatch, Command: command, ExitCode: eexitCode, Err: err}
+	}
+	if eexitCode != 0 {
+		return &HookError{Hook: typeOfCatch, Command: command, ExitCode: eexitCode}
+	}
+	return nil
+}
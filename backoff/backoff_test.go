@@ -0,0 +1,1018 @@
+/*
+* Copyright 2020-present, Synopsys, Inc. * All rights reserved.
+*
+* This source code is licensed under the Apache-2.0 license found in
+* the LICENSE file in the root directory of this source tree. */
+
+package backoff
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	policy := NewPolicy()
+	policy.Expression = "1"
+	policy.Retries = 4
+	policy.Duration = 10 * time.Second
+	policy.RetryOnAll = true
+
+	result := Run(context.Background(), policy, []string{"echo", "hi"})
+	if result.ExitCode != 0 || result.Reason != Succeeded {
+		t.Errorf("expected success, got exit code %d (%s)", result.ExitCode, result.Reason)
+	}
+	if len(result.Attempts) != 1 {
+		t.Errorf("expected 1 attempt, got %d", len(result.Attempts))
+	}
+	if result.Stdout != "hi\n" {
+		t.Errorf("expected stdout to be captured, got %q", result.Stdout)
+	}
+}
+
+func TestRunRetriesExhausted(t *testing.T) {
+	policy := NewPolicy()
+	policy.Retries = 2
+	policy.RetryOnStrings = []StringMatcher{{Text: "try again"}}
+
+	result := Run(context.Background(), policy, []string{"sh", "-c", "echo try again; exit 4"})
+	if result.ExitCode != 4 || result.Reason != RetriesExhausted {
+		t.Errorf("expected exit code 4 with retries exhausted, got %d (%s)", result.ExitCode, result.Reason)
+	}
+	if len(result.Attempts) != 3 {
+		t.Errorf("expected 3 attempts, got %d", len(result.Attempts))
+	}
+	if result.Rule != `retry_on_string_matches "try again" on stdout` {
+		t.Errorf("unexpected rule %q", result.Rule)
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	policy := NewPolicy()
+	policy.Expression = "600"
+	policy.RetryOnAll = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	result := Run(ctx, policy, []string{"false"})
+	if result.Reason != Cancelled {
+		t.Errorf("expected the run to be cancelled, got %s", result.Reason)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("cancelling did not interrupt the wait between attempts")
+	}
+
+	// Cancelled before any attempt, the exit code is that of an attempt
+	// stopped with the signal that would have been sent
+	ctx, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	if result = Run(ctx, policy, []string{"true"}); result.Reason != Cancelled || len(result.Attempts) != 0 || result.ExitCode != 128+int(syscall.SIGTERM) {
+		t.Errorf("expected no attempt and exit code %d, got %d after %d attempts (%s)", 128+int(syscall.SIGTERM), result.ExitCode, len(result.Attempts), result.Reason)
+	}
+}
+
+func TestRunStartFailed(t *testing.T) {
+	policy := NewPolicy()
+	policy.Retries = 1
+	policy.RetryOnAll = true
+
+	result := Run(context.Background(), policy, []string{"./no-such-command"})
+	if len(result.Attempts) != 2 || result.Reason != RetriesExhausted || result.ExitCode != InternalErrorExitCode || result.Err == nil {
+		t.Errorf("expected a command that cannot start to exit with %d, got %d (%s) after %d attempts: %v", InternalErrorExitCode, result.ExitCode, result.Reason, len(result.Attempts), result.Err)
+	}
+}
+
+func TestRunNoCommand(t *testing.T) {
+	for _, command := range [][]string{nil, {}} {
+		result := Run(context.Background(), NewPolicy(), command)
+		var commandErr *CommandError
+		if len(result.Attempts) != 0 || result.ExitCode != InternalErrorExitCode || !errors.As(result.Err, &commandErr) {
+			t.Errorf("expected no command to exit with %d and a CommandError, got %d after %d attempts: %v", InternalErrorExitCode, result.ExitCode, len(result.Attempts), result.Err)
+		}
+	}
+}
+
+func TestRunCancelledStopsCommand(t *testing.T) {
+	policy := NewPolicy()
+	policy.KillGracePeriod = 100 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	result := Run(ctx, policy, []string{"sleep", "30"})
+	if result.Reason != Cancelled {
+		t.Errorf("expected the run to be cancelled, got %s", result.Reason)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("cancelling did not stop the running command")
+	}
+}
+
+func TestRunKilled(t *testing.T) {
+	policy := NewPolicy()
+	policy.KillGracePeriod = 30 * time.Second
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	ctx, kill := WithKill(ctx)
+	time.AfterFunc(200*time.Millisecond, func() { cancel(&SignalError{Signal: syscall.SIGINT}) })
+	time.AfterFunc(400*time.Millisecond, kill)
+
+	// The command ignores the first signal, so only the kill stops it
+	start := time.Now()
+	result := Run(ctx, policy, []string{"sh", "-c", "trap '' INT; sleep 30"})
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("killing did not cut the grace period short")
+	}
+	if result.Reason != Cancelled || result.ExitCode != 128+int(syscall.SIGINT) {
+		t.Errorf("expected to be cancelled with exit code %d, got %s with %d", 128+int(syscall.SIGINT), result.Reason, result.ExitCode)
+	}
+}
+
+func TestRunCancelledBySignal(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		signal   syscall.Signal
+		command  []string
+		attempts int
+	}{
+		// The signal is passed on to the running command
+		{"attempt", syscall.SIGHUP, []string{"sleep", "30"}, 1},
+		{"sleep", syscall.SIGINT, []string{"false"}, 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			hook := filepath.Join(t.TempDir(), "exited")
+			policy := NewPolicy()
+			policy.Expression = "600"
+			policy.RetryOnAll = true
+			policy.KillGracePeriod = 100 * time.Millisecond
+			policy.PerformOnExit = "touch " + hook
+
+			ctx, cancel := context.WithCancelCause(context.Background())
+			defer cancel(nil)
+			time.AfterFunc(200*time.Millisecond, func() { cancel(&SignalError{Signal: test.signal}) })
+
+			result := Run(ctx, policy, test.command)
+			var signalErr *SignalError
+			if result.Reason != Cancelled || result.ExitCode != 128+int(test.signal) || !errors.As(result.Err, &signalErr) {
+				t.Errorf("expected to be cancelled with exit code %d, got %s with %d: %v", 128+int(test.signal), result.Reason, result.ExitCode, result.Err)
+			}
+			if len(result.Attempts) != test.attempts {
+				t.Fatalf("expected %d attempts, got %d", test.attempts, len(result.Attempts))
+			}
+			if test.command[0] == "sleep" && result.Attempts[0].Signal != test.signal {
+				t.Errorf("expected the command to be stopped with %s, got %s", SignalName(test.signal), SignalName(result.Attempts[0].Signal))
+			}
+			if _, err := os.Stat(hook); err != nil {
+				t.Errorf("expected perform on exit to run after the signal: %v", err)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	var exitCodeErr *ExitCodeError
+	if _, err := ParseExitCodes("1,two"); !errors.As(err, &exitCodeErr) {
+		t.Errorf("expected an ExitCodeError, got %v", err)
+	}
+	var regexpErr *RegexpError
+	if _, err := ParseRegexps("ok,(unclosed"); !errors.As(err, &regexpErr) || regexpErr.Regexp != "(unclosed" {
+		t.Errorf("expected a RegexpError for (unclosed, got %v", err)
+	}
+	var commandErr *CommandError
+	if _, err := ParseCommand("echo 'unterminated"); !errors.As(err, &commandErr) {
+		t.Errorf("expected a CommandError, got %v", err)
+	}
+}
+
+func TestRunHookFailed(t *testing.T) {
+	policy := NewPolicy()
+	policy.RetryOnAll = true
+	policy.PerformOnFailure = "false"
+
+	result := Run(context.Background(), policy, []string{"false"})
+	var hookErr *HookError
+	if result.Reason != HookFailed || !errors.As(result.Err, &hookErr) {
+		t.Errorf("expected a failed hook, got %s (%v)", result.Reason, result.Err)
+	}
+}
+
+var errUnavailable = errors.New("service unavailable")
+
+func TestDo(t *testing.T) {
+	policy := NewPolicy()
+	policy.Retries = 5
+	policy.RetryOnErrors = []ErrorMatcher{ErrorIs(errUnavailable)}
+
+	calls := 0
+	value, err := Do(context.Background(), policy, func(ctx context.Context) (string, error) {
+		calls++
+		if calls < 3 {
+			return "", fmt.Errorf("dialing: %w", errUnavailable)
+		}
+		return "ok", nil
+	})
+	if err != nil || value != "ok" || calls != 3 {
+		t.Errorf("expected ok after 3 calls, got %q, %v after %d calls", value, err, calls)
+	}
+}
+
+func TestDoGivesUp(t *testing.T) {
+	policy := NewPolicy()
+	policy.Retries = 1
+	policy.RetryOnRegexps, _ = ParseRegexpMatchers("unavail.*")
+
+	calls := 0
+	_, err := Do(context.Background(), policy, func(ctx context.Context) (int, error) {
+		calls++
+		return 0, errUnavailable
+	})
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || retryErr.Reason != RetriesExhausted || !errors.Is(err, errUnavailable) {
+		t.Errorf("expected retries to be exhausted, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 calls, got %d", calls)
+	}
+}
+
+func TestRunStreamed(t *testing.T) {
+	var stdout bytes.Buffer
+	policy := NewPolicy()
+	policy.Retries = 1
+	policy.RetryOnAll = true
+	policy.OutputMode = Streamed
+	policy.PrefixOutput = true
+	policy.Stdout = &stdout
+
+	result := Run(context.Background(), policy, []string{"sh", "-c", "echo one; echo two; exit 1"})
+	expected := "[attempt 1] one\n[attempt 1] two\n[attempt 2] one\n[attempt 2] two\n"
+	if stdout.String() != expected {
+		t.Errorf("expected streamed output %q, got %q", expected, stdout.String())
+	}
+	if result.Stdout != "one\ntwo\n" {
+		t.Errorf("expected the final attempt to still be captured, got %q", result.Stdout)
+	}
+}
+
+func TestRunAttemptTimeout(t *testing.T) {
+	policy := NewPolicy()
+	policy.Retries = 1
+	policy.AttemptTimeout = 100 * time.Millisecond
+	policy.RetryOnTimeout = true
+	policy.KillGracePeriod = 100 * time.Millisecond
+
+	result := Run(context.Background(), policy, []string{"sleep", "30"})
+	if result.ExitCode != TimeoutExitCode || result.Reason != RetriesExhausted {
+		t.Errorf("expected exit code %d with retries exhausted, got %d (%s)", TimeoutExitCode, result.ExitCode, result.Reason)
+	}
+	if len(result.Attempts) != 2 || !result.Attempts[0].TimedOut || !result.Attempts[1].TimedOut {
+		t.Errorf("expected 2 timed out attempts, got %+v", result.Attempts)
+	}
+}
+
+func TestRunPassthroughTimeout(t *testing.T) {
+	policy := NewPolicy()
+	policy.Retries = 0
+	policy.AttemptTimeout = 200 * time.Millisecond
+	policy.KillGracePeriod = 0
+	policy.PassthroughStdin = true
+	policy.Stdin = strings.NewReader("")
+
+	// A passed through command is stopped as any other is
+	start := time.Now()
+	result := Run(context.Background(), policy, []string{"sleep", "30"})
+	if result.Reason != TimedOut || time.Since(start) > 5*time.Second {
+		t.Errorf("expected the passed through command to be stopped by the timeout, got %s after %s", result.Reason, time.Since(start))
+	}
+}
+
+func TestRunStopsProcessGroup(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("process groups are unix only")
+	}
+	for _, test := range []struct {
+		name    string
+		timeout time.Duration
+		cancel  time.Duration
+	}{
+		{"timeout", 200 * time.Millisecond, 0},
+		{"cancel", 0, 200 * time.Millisecond},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			policy := NewPolicy()
+			policy.Retries = 0
+			policy.AttemptTimeout = test.timeout
+			policy.KillGracePeriod = 100 * time.Millisecond
+			ctx := context.Background()
+			if test.cancel > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, test.cancel)
+				defer cancel()
+			}
+
+			// The shell leads the group, and prints its id
+			result := Run(ctx, policy, []string{"sh", "-c", "echo $$; sleep 30 & sleep 30"})
+			group := strings.TrimSpace(result.Stdout)
+			if group == "" {
+				t.Fatalf("expected the shell to print its process group, got %+v", result)
+			}
+			out, err := exec.Command("ps", "-eo", "pgid=,stat=,args=").Output()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range strings.Split(string(out), "\n") {
+				// Killed sleeps are left as zombies until init reaps them
+				fields := strings.Fields(line)
+				if len(fields) > 1 && fields[0] == group && !strings.HasPrefix(fields[1], "Z") {
+					t.Errorf("expected nothing left running in process group %s, found %q", group, line)
+				}
+			}
+		})
+	}
+}
+
+func TestRunReplaysStdin(t *testing.T) {
+	var stdout bytes.Buffer
+	policy := NewPolicy()
+	policy.Retries = 2
+	policy.RetryOnAll = true
+	policy.Stdin = strings.NewReader("line one\nline two\n")
+	policy.StdinMemoryLimit = 4
+	policy.OutputMode = Streamed
+	policy.Stdout = &stdout
+
+	Run(context.Background(), policy, []string{"sh", "-c", "wc -l; exit 1"})
+	if lines := strings.Fields(stdout.String()); len(lines) != 3 || lines[0] != "2" || lines[2] != "2" {
+		t.Errorf("expected every attempt to read 2 lines, got %q", stdout.String())
+	}
+}
+
+func TestRunStdinNeverClosed(t *testing.T) {
+	// Like the stdin of a CI job, a pipe that is never closed
+	neverClosed := func(input string) io.Reader {
+		reader, writer := io.Pipe()
+		t.Cleanup(func() { writer.Close() })
+		go writer.Write([]byte(input))
+		return reader
+	}
+
+	start := time.Now()
+	policy := NewPolicy()
+	policy.Stdin = neverClosed("")
+	if result := Run(context.Background(), policy, []string{"true"}); result.ExitCode != 0 {
+		t.Errorf("expected a command that ignores stdin to succeed, got %d", result.ExitCode)
+	}
+
+	var stdout bytes.Buffer
+	policy = NewPolicy()
+	policy.Retries = 1
+	policy.RetryOnAll = true
+	policy.Stdin = neverClosed("first\n")
+	policy.OutputMode = Streamed
+	policy.Stdout = &stdout
+	result := Run(context.Background(), policy, []string{"sh", "-c", "head -n 1; exit 1"})
+	if len(result.Attempts) != 2 || stdout.String() != "first\nfirst\n" {
+		t.Errorf("expected both attempts to read the first line, got %q after %d attempts", stdout.String(), len(result.Attempts))
+	}
+
+	// A command waiting on more input can still be stopped
+	policy = NewPolicy()
+	policy.Stdin = neverClosed("")
+	policy.KillGracePeriod = 100 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if result = Run(ctx, policy, []string{"cat"}); result.Reason != Cancelled {
+		t.Errorf("expected the run to be cancelled, got %s", result.Reason)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("expected stdin that is never closed not to hold up the command")
+	}
+}
+
+func TestRunAttemptEnv(t *testing.T) {
+	var stdout bytes.Buffer
+	policy := NewPolicy()
+	policy.Retries = 1
+	policy.RetryOnAll = true
+	policy.OutputMode = Streamed
+	policy.Stdout = &stdout
+
+	result := Run(context.Background(), policy, []string{"sh", "-c", `echo "$EB_ATTEMPT $EB_ATTEMPT_ZERO_BASED $EB_MAX_RETRIES $EB_REMAINING_SECONDS [$EB_PREVIOUS_EXIT_CODE] $EB_RUN_ID"; exit 3`})
+	expected := "1 0 1 -1 [] " + result.RunID + "\n2 1 1 -1 [3] " + result.RunID + "\n"
+	if stdout.String() != expected {
+		t.Errorf("expected %q, got %q", expected, stdout.String())
+	}
+}
+
+func TestRunExpressionVariables(t *testing.T) {
+	policy := NewPolicy()
+	policy.Retries = 2
+	policy.RetryOnAll = true
+	policy.Expression = "exit_code/100 + prev_sleep"
+
+	result := Run(context.Background(), policy, []string{"sh", "-c", "exit 5"})
+	if len(result.Attempts) != 3 || result.Attempts[0].Sleep != 50*time.Millisecond || result.Attempts[1].Sleep != 100*time.Millisecond {
+		t.Errorf("expected sleeps of 50ms then 100ms, got %+v", result.Attempts)
+	}
+}
+
+func TestExpressionFunctions(t *testing.T) {
+	state := loopState{xIncrement: 10}
+	policy := NewPolicy()
+	policy.Expression = "min(300, pow(2, x)) + floor(rand(0, 5)) - ceil(0.5) + max(exp(0), 0)"
+	sleepFor, err := nextSleep(policy, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sleepFor < 300*time.Second || sleepFor >= 305*time.Second {
+		t.Errorf("expected a sleep from 300s-305s, got %s", sleepFor)
+	}
+
+	policy.Expression = "sqrt(x)"
+	var formulaErr *FormulaError
+	if _, err := compileFormula(policy, rand.New(rand.NewSource(1))); !errors.As(err, &formulaErr) || !strings.Contains(err.Error(), "Available functions are ceil, exp, floor, max, min, pow, rand") {
+		t.Errorf("expected an error listing the available functions, got %v", err)
+	}
+
+	policy.Expression = "pow(2)"
+	if _, err := compileFormula(policy, rand.New(rand.NewSource(1))); err == nil || !strings.Contains(err.Error(), "pow() takes 2 arguments, got 1") {
+		t.Errorf("expected an error about the number of arguments, got %v", err)
+	}
+}
+
+// nextSleep compiles the policy's formula and evaluates it for state
+func nextSleep(policy Policy, state loopState) (time.Duration, error) {
+	f, err := compileFormula(policy, rand.New(rand.NewSource(1)))
+	if err != nil {
+		return 0, err
+	}
+	return f.nextSleep(policy, state)
+}
+
+func TestStrategies(t *testing.T) {
+	expected := map[string][]time.Duration{
+		"constant":    {2 * time.Second, 2 * time.Second, 2 * time.Second, 2 * time.Second},
+		"linear":      {2 * time.Second, 5 * time.Second, 8 * time.Second, 10 * time.Second},
+		"exponential": {2 * time.Second, 6 * time.Second, 10 * time.Second, 10 * time.Second},
+		"fibonacci":   {2 * time.Second, 2 * time.Second, 4 * time.Second, 6 * time.Second},
+	}
+	for name, sleeps := range expected {
+		policy := NewPolicy()
+		policy.Strategy = Strategy{Name: name, Base: 2, Factor: 3, Cap: 10}
+		for i, want := range sleeps {
+			got, err := nextSleep(policy, loopState{xIncrement: i + 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("%s: expected retry %d to wait %s, got %s", name, i+1, want, got)
+			}
+		}
+	}
+
+	policy := NewPolicy()
+	policy.Strategy = Strategy{Name: "decorrelated-jitter", Base: 1, Cap: 10, Min: 2}
+	for i := 1; i < 20; i++ {
+		got, _ := nextSleep(policy, loopState{xIncrement: i, prevSleep: 5 * time.Second})
+		if got < 2*time.Second || got > 10*time.Second {
+			t.Errorf("expected decorrelated-jitter to wait from 2s-10s, got %s", got)
+		}
+	}
+
+	policy.Expression = "0.5"
+	if got, _ := nextSleep(policy, loopState{xIncrement: 1}); got != 500*time.Millisecond {
+		t.Errorf("expected the expression to win over the strategy, got %s", got)
+	}
+
+	if _, err := ParseStrategy("exponental"); err == nil {
+		t.Errorf("expected an unknown strategy to be rejected")
+	}
+}
+
+func TestRunExpressionValidated(t *testing.T) {
+	policy := NewPolicy()
+	policy.RetryOnAll = true
+	for _, expression := range []string{"15*", "15*y", "x > 1", "pow(2)"} {
+		policy.Expression = expression
+		result := Run(context.Background(), policy, []string{"false"})
+		var formulaErr *FormulaError
+		if result.ExitCode != InternalErrorExitCode || result.Reason != ExpressionError || !errors.As(result.Err, &formulaErr) {
+			t.Errorf("%s: expected an expression error, got %d (%s) %v", expression, result.ExitCode, result.Reason, result.Err)
+		}
+		if len(result.Attempts) != 0 {
+			t.Errorf("%s: expected no attempts, got %d", expression, len(result.Attempts))
+		}
+	}
+
+	policy.Expression = "0.01 - x"
+	policy.Retries = 2
+	result := Run(context.Background(), policy, []string{"false"})
+	if len(result.Attempts) != 3 || result.Attempts[1].Sleep != 0 {
+		t.Errorf("expected a negative wait to retry straight away, got %+v", result.Attempts)
+	}
+}
+
+func TestPreview(t *testing.T) {
+	policy := NewPolicy()
+	policy.Expression = "10*i + r"
+	policy.Duration = 45 * time.Second
+
+	schedule, err := Preview(policy, 1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schedule.Steps) != 3 || schedule.Reason != DurationExhausted || schedule.Total() != 45*time.Second {
+		t.Fatalf("expected 3 waits totalling 45s, got %+v", schedule)
+	}
+	if schedule.Steps[1].Clamped() || !schedule.Steps[2].Clamped() || schedule.Steps[2].Attempt != 4 {
+		t.Errorf("expected only the wait before attempt 4 to be clamped, got %+v", schedule.Steps)
+	}
+	again, _ := Preview(policy, 1, 100)
+	if again.Steps[0].Sleep != schedule.Steps[0].Sleep {
+		t.Errorf("expected the same seed to give the same schedule")
+	}
+
+	policy.Duration = -1
+	if schedule, _ := Preview(policy, 1, 5); len(schedule.Steps) != 5 || !schedule.Truncated {
+		t.Errorf("expected an unlimited schedule to stop at the limit, got %+v", schedule)
+	}
+}
+
+func TestRunRules(t *testing.T) {
+	rule, err := ParseRuleSpec(`name=conflict string_matches="already exists" action=succeed`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := NewPolicy()
+	policy.RetryOnAll = true
+	policy.Rules = []Rule{rule, {Name: "fatal", ExitCodes: []int{7}, Action: Fail}}
+
+	result := Run(context.Background(), policy, []string{"sh", "-c", "echo already exists; exit 7"})
+	if result.ExitCode != 0 || result.Reason != Succeeded || result.Rule != `rule "conflict" string_matches "already exists" on stdout` {
+		t.Errorf("expected the first rule to succeed, got %d (%s) from %q", result.ExitCode, result.Reason, result.Rule)
+	}
+	result = Run(context.Background(), policy, []string{"sh", "-c", "exit 7"})
+	if len(result.Attempts) != 1 || result.Reason != FailOnMatch {
+		t.Errorf("expected the second rule to stop retrying, got %s after %d attempts", result.Reason, len(result.Attempts))
+	}
+	if result.ExitCode != 7 {
+		t.Errorf("expected a fail rule to keep the command's exit code, got %d", result.ExitCode)
+	}
+
+	if _, err := ParseRuleSpec("name=bad action=maybe"); err == nil {
+		t.Errorf("expected an unknown action to be rejected")
+	}
+}
+
+func TestRunRetryRuleOnSuccess(t *testing.T) {
+	// A retry rule, like the retry_on settings, leaves an attempt that
+	// exited with 0 to succeed
+	rule, err := ParseRuleSpec(`name=quota string_matches="Quota exceeded"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := NewPolicy()
+	policy.Expression = "0"
+	policy.Retries = 2
+	policy.Rules = []Rule{rule}
+	quota := []string{"echo", "Quota exceeded"}
+	result := Run(context.Background(), policy, quota)
+	if len(result.Attempts) != 1 || result.ExitCode != 0 || result.Reason != Succeeded {
+		t.Errorf("expected the attempt to succeed, got %d (%s) after %d attempts", result.ExitCode, result.Reason, len(result.Attempts))
+	}
+	if result = Run(context.Background(), policy, []string{"sh", "-c", "echo Quota exceeded; exit 1"}); len(result.Attempts) != 3 {
+		t.Errorf("expected a failed attempt to be retried, got %d attempts", len(result.Attempts))
+	}
+
+	// Unless it is asked to retry those too
+	if policy.Rules[0], err = ParseRuleSpec(`name=quota string_matches="Quota exceeded" on_success=true`); err != nil {
+		t.Fatal(err)
+	}
+	if result = Run(context.Background(), policy, quota); len(result.Attempts) != 3 || result.Reason != RetriesExhausted {
+		t.Errorf("expected on_success to retry the attempt, got %s after %d attempts", result.Reason, len(result.Attempts))
+	}
+}
+
+func TestRunRuleOrder(t *testing.T) {
+	var explained bytes.Buffer
+	policy := NewPolicy()
+	policy.Retries = 1
+	policy.RetryOnAll = true
+	policy.FailOnStrings = []StringMatcher{{Text: "fatal"}}
+	policy.SuccessOnStrings = []StringMatcher{{Text: "fatal"}}
+	policy.Rules = []Rule{
+		{Name: "noise", Strings: []StringMatcher{{Text: "warn"}}, Action: Continue},
+		{Name: "quota", Strings: []StringMatcher{{Text: "Quota exceeded"}}, Action: Retry},
+	}
+	policy.Explain = true
+	policy.Stderr = &explained
+
+	// success_on is tried before fail_on and retry_on_all, and the first
+	// to match wins
+	result := Run(context.Background(), policy, []string{"sh", "-c", "echo warn fatal; exit 3"})
+	if len(result.Attempts) != 1 || result.Reason != Succeeded || result.Rule != `success_on_string_matches "fatal" on stdout` {
+		t.Errorf("expected success_on to decide, got %s after %d attempts from %q", result.Reason, len(result.Attempts), result.Rule)
+	}
+	for _, line := range []string{
+		`  1. rule "noise": string_matches ["warn"] -> continue`,
+		`  2. rule "quota": string_matches ["Quota exceeded"], on failure -> retry`,
+		`  3. success_on_string_matches: string_matches ["fatal"] -> succeed`,
+		`  4. fail_on_string_matches: string_matches ["fatal"] -> fail-continue`,
+		`  5. retry_on_all: every attempt, on failure -> retry`,
+		`Attempt 1: rule "noise" string_matches "warn" on stdout -> continue`,
+		`Attempt 1: success_on_string_matches "fatal" on stdout -> succeed`,
+	} {
+		if !strings.Contains(explained.String(), line+"\n") {
+			t.Errorf("expected the explanation to contain %q, got:\n%s", line, explained.String())
+		}
+	}
+
+	// A retry rule leaves an attempt that exited with 0 to succeed
+	explained.Reset()
+	result = Run(context.Background(), policy, []string{"sh", "-c", "echo warn; echo Quota exceeded"})
+	if len(result.Attempts) != 1 || result.Reason != Succeeded {
+		t.Errorf("expected the attempt to succeed, got %s after %d attempts", result.Reason, len(result.Attempts))
+	}
+	if !strings.Contains(explained.String(), "Attempt 1: no rule matched exit code 0 -> succeed\n") || strings.Contains(explained.String(), `Attempt 1: rule "quota"`) {
+		t.Errorf("expected the explanation to show the attempt succeeding, got:\n%s", explained.String())
+	}
+
+	// Without a match the exit code decides
+	policy.Explain = false
+	result = Run(context.Background(), policy, []string{"sh", "-c", "echo warn"})
+	if result.Reason != Succeeded || result.Rule != "" {
+		t.Errorf("expected an unmatched exit code of 0 to succeed, got %s from %q", result.Reason, result.Rule)
+	}
+}
+
+func TestRunFailOnRetried(t *testing.T) {
+	// A fail_on match is a failure that retry_on_all still retries, as
+	// eb "..." -e 0 -o failed -a -r 3 always has
+	policy := NewPolicy()
+	policy.Expression = "0"
+	policy.Retries = 3
+	policy.RetryOnAll = true
+	policy.FailOnStrings, _ = ParseStringMatchers("failed")
+	result := Run(context.Background(), policy, []string{"sh", "-c", "echo 200 OK: The web request failed"})
+	if len(result.Attempts) != 4 || result.Reason != RetriesExhausted || result.ExitCode != ForcedFailureExitCode || result.Rule != "retry_on_all" {
+		t.Errorf("expected fail_on to be retried, got %s with %d after %d attempts from %q", result.Reason, result.ExitCode, len(result.Attempts), result.Rule)
+	}
+
+	// As is anything that does not match fail_unless
+	policy.FailOnStrings = nil
+	policy.FailUnlessStrings, _ = ParseStringMatchers("succeeded")
+	if result = Run(context.Background(), policy, []string{"echo", "200 OK: Your request failed"}); len(result.Attempts) != 4 || result.ExitCode != ForcedFailureExitCode {
+		t.Errorf("expected fail_unless to be retried, got %d after %d attempts", result.ExitCode, len(result.Attempts))
+	}
+	if result = Run(context.Background(), policy, []string{"sh", "-c", "echo 200 OK: Your request succeeded; exit 3"}); len(result.Attempts) != 1 || result.ExitCode != 0 {
+		t.Errorf("expected a fail_unless match to succeed, got %d after %d attempts", result.ExitCode, len(result.Attempts))
+	}
+
+	// success_on wins over fail_on, as eb "..." -o boom -S boom always has
+	policy = NewPolicy()
+	policy.FailOnStrings, _ = ParseStringMatchers("boom")
+	policy.SuccessOnStrings, _ = ParseStringMatchers("boom")
+	if result = Run(context.Background(), policy, []string{"echo", "boom"}); result.ExitCode != 0 || result.Reason != Succeeded {
+		t.Errorf("expected success_on to win over fail_on, got %d (%s)", result.ExitCode, result.Reason)
+	}
+
+	// fail_unless does not turn a fail_on match back into a success, as
+	// eb "..." -o fatal -u ok once did
+	policy = NewPolicy()
+	policy.FailOnStrings, _ = ParseStringMatchers("fatal")
+	policy.FailUnlessStrings, _ = ParseStringMatchers("ok")
+	if result = Run(context.Background(), policy, []string{"echo", "fatal ok"}); result.Reason != FailOnMatch || result.Rule != `fail_on_string_matches "fatal" on stdout` {
+		t.Errorf("expected fail_on to win over fail_unless, got %s from %q", result.Reason, result.Rule)
+	}
+
+	// A rule can fail an attempt the same way
+	rule, err := ParseRuleSpec("name=oops string_matches=oops action=fail-continue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy = NewPolicy()
+	policy.Retries = 1
+	policy.RetryOnAll = true
+	policy.Rules = []Rule{rule, {Name: "fine", Strings: []StringMatcher{{Text: "fine"}}, Action: Succeed}}
+	if result = Run(context.Background(), policy, []string{"echo", "oops fine"}); len(result.Attempts) != 2 || result.ExitCode != ForcedFailureExitCode || result.Rule != "retry_on_all" {
+		t.Errorf("expected the rule to fail the attempt and retry_on_all to retry it, got %d after %d attempts from %q", result.ExitCode, len(result.Attempts), result.Rule)
+	}
+
+	// Without a later match the failure stands
+	policy = NewPolicy()
+	policy.FailOnStrings, _ = ParseStringMatchers("boom")
+	policy.RetryOnStrings, _ = ParseStringMatchers("timeout")
+	if result = Run(context.Background(), policy, []string{"echo", "boom"}); len(result.Attempts) != 1 || result.Reason != FailOnMatch || result.Rule != `fail_on_string_matches "boom" on stdout` {
+		t.Errorf("expected fail_on to fail the command, got %s after %d attempts from %q", result.Reason, len(result.Attempts), result.Rule)
+	}
+
+	// The rules after fail_on see the command's own exit code, not 255, as
+	// eb "sh -c 'echo denied; exit 1'" -o denied -c 255 -r 3 -e 0
+	policy = NewPolicy()
+	policy.Expression = "0"
+	policy.Retries = 3
+	policy.FailOnStrings, _ = ParseStringMatchers("denied")
+	policy.RetryOnExitCodes = []int{ForcedFailureExitCode}
+	denied := []string{"sh", "-c", "echo denied; exit 1"}
+	if result = Run(context.Background(), policy, denied); len(result.Attempts) != 1 || result.ExitCode != ForcedFailureExitCode || result.Reason != FailOnMatch {
+		t.Errorf("expected retry_on_exit_codes not to see 255, got %d (%s) after %d attempts", result.ExitCode, result.Reason, len(result.Attempts))
+	}
+	policy.RetryOnExitCodes = []int{1}
+	if result = Run(context.Background(), policy, denied); len(result.Attempts) != 4 || result.ExitCode != ForcedFailureExitCode || result.Reason != RetriesExhausted {
+		t.Errorf("expected retry_on_exit_codes to retry exit code 1, got %d (%s) after %d attempts", result.ExitCode, result.Reason, len(result.Attempts))
+	}
+	policy.RetryOnExitCodes = nil
+	policy.RetryIf = "exit_code == 1"
+	if result = Run(context.Background(), policy, denied); len(result.Attempts) != 4 || result.ExitCode != ForcedFailureExitCode {
+		t.Errorf("expected retry_if to see exit code 1, got %d after %d attempts", result.ExitCode, len(result.Attempts))
+	}
+}
+
+func TestRunScopedMatchers(t *testing.T) {
+	policy := NewPolicy()
+	policy.Retries = 1
+	policy.RetryOnStrings, _ = ParseStringMatchers("stderr:error")
+	policy.RetryOnRegexps, _ = ParseRegexpMatchers("stdout:^retry")
+
+	result := Run(context.Background(), policy, []string{"sh", "-c", "echo error; echo retry >&2; exit 1"})
+	if len(result.Attempts) != 1 || result.Reason != Failed {
+		t.Errorf("expected matchers scoped to the other stream not to retry, got %s after %d attempts from %q", result.Reason, len(result.Attempts), result.Rule)
+	}
+	result = Run(context.Background(), policy, []string{"sh", "-c", "echo error >&2; exit 1"})
+	if len(result.Attempts) != 2 || result.Rule != `retry_on_string_matches "error" on stderr` {
+		t.Errorf("expected a match on stderr to retry, got %d attempts from %q", len(result.Attempts), result.Rule)
+	}
+
+	matchers, err := ParseStringMatchers(`any:stdout:x,stdout:y,z`)
+	if err != nil || len(matchers) != 3 {
+		t.Fatalf("unexpected matchers %v: %v", matchers, err)
+	}
+	for i, expected := range []StringMatcher{{AnyStream, "stdout:x"}, {StdoutStream, "y"}, {AnyStream, "z"}} {
+		if matchers[i] != expected {
+			t.Errorf("expected %+v, got %+v", expected, matchers[i])
+		}
+	}
+	if matchers[0].String() != "any:stdout:x" {
+		t.Errorf("expected the scope to be kept when written out, got %q", matchers[0])
+	}
+}
+
+func TestMatchWindow(t *testing.T) {
+	for _, test := range []struct {
+		window   MatchWindow
+		text     string
+		expected string
+	}{
+		{MatchWindow{}, "a\nb\nc\n", "a\nb\nc\n"},
+		{MatchWindow{Lines: 2}, "a\nb\nc\n", "b\nc\n"},
+		{MatchWindow{Lines: 2}, "a\nb\nc", "b\nc"},
+		{MatchWindow{Lines: 5}, "a\nb\nc\n", "a\nb\nc\n"},
+		{MatchWindow{Bytes: 3}, "abcdef", "def"},
+		{MatchWindow{Bytes: 3}, "abcdé", "dé"},
+		{MatchWindow{Lines: 2, Bytes: 3}, "a\nbbbb\ncc\n", "cc\n"},
+	} {
+		if tail := test.window.tail(test.text); tail != test.expected {
+			t.Errorf("expected the %s of %q to be %q, got %q", test.window, test.text, test.expected, tail)
+		}
+	}
+	if w, err := ParseMatchWindow("200 lines,64 bytes"); err != nil || w != (MatchWindow{Lines: 200, Bytes: 64}) {
+		t.Errorf("unexpected window %v: %v", w, err)
+	}
+	if _, err := ParseMatchWindow("200"); err == nil {
+		t.Errorf("expected a window without a unit to be rejected")
+	}
+
+	policy := NewPolicy()
+	policy.Retries = 1
+	policy.RetryOnStrings, _ = ParseStringMatchers("try again")
+	policy.MatchWindow = MatchWindow{Lines: 2}
+	result := Run(context.Background(), policy, []string{"sh", "-c", "echo try again; echo one; echo two; exit 1"})
+	if len(result.Attempts) != 1 {
+		t.Errorf("expected a match before the window not to retry, got %d attempts from %q", len(result.Attempts), result.Rule)
+	}
+}
+
+func TestMatcherSet(t *testing.T) {
+	patterns := []string{"he", "she", "his", "hers", "", "x", "sh"}
+	ac := newAhoCorasick(patterns)
+	random := rand.New(rand.NewSource(1))
+	for n := 0; n < 1000; n++ {
+		text := make([]byte, random.Intn(12))
+		for i := range text {
+			text[i] = "hersix"[random.Intn(6)]
+		}
+		contains := ac.contains(string(text))
+		for p, pattern := range patterns {
+			if contains[p] != strings.Contains(string(text), pattern) {
+				t.Fatalf("expected %q containing %q to be %v", text, pattern, !contains[p])
+			}
+		}
+	}
+
+	// Regexps that overlap, and that have groups of their own, are told
+	// apart as if each had been run on its own
+	expressions := []string{"he", "hers?", "(s)(h)e", "^x", "i(x|s)$", "e*"}
+	var matchers []RegexpMatcher
+	for _, expression := range expressions {
+		matchers = append(matchers, RegexpMatcher{Regexp: regexp.MustCompile(expression)})
+	}
+	set, err := newMatcherSet([]Rule{{Name: "regexps", Regexps: matchers}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < 1000; n++ {
+		text := make([]byte, random.Intn(12))
+		for i := range text {
+			text[i] = "hersix"[random.Intn(6)]
+		}
+		sc := set.scan(MatchWindow{}, commandOutcome(1, 0, string(text), ""))
+		for i, m := range matchers {
+			if sc.matchesRegexp(0, i, 0) != m.MatchString(string(text)) {
+				t.Fatalf("expected %q matching %q to be %v", text, m, !sc.matchesRegexp(0, i, 0))
+			}
+		}
+	}
+
+	// A regexp nested as deeply as it can be cannot be joined with the
+	// others, so it alone is run on its own
+	deep := regexp.MustCompile(strings.Repeat("(", 999) + "deep" + strings.Repeat(")", 999))
+	set, err = newMatcherSet([]Rule{{Name: "regexps", Regexps: []RegexpMatcher{{Regexp: deep}, {Regexp: regexp.MustCompile("sh")}, {Regexp: regexp.MustCompile("e$")}}}})
+	if err != nil {
+		t.Fatalf("expected regexps that cannot be joined to be searched for on their own, got %v", err)
+	}
+	if set.regexps == nil || !set.alone[0] || set.alone[1] || set.alone[2] {
+		t.Fatalf("expected only the deep regexp to be left out of the joined one, got %v", set.alone)
+	}
+	sc := set.scan(MatchWindow{}, commandOutcome(1, 0, "she", "too deep"))
+	// The joined pass marks the others, so none is run again
+	if sc.matched[0][0] || !sc.matched[0][1] || !sc.matched[0][2] || !sc.matched[1][0] || sc.matched[1][1] || sc.found[1] {
+		t.Errorf("expected the scan to mark which regexps matched, got %v", sc.matched)
+	}
+	if sc.matchesRegexp(0, 0, 0) || !sc.matchesRegexp(0, 1, 0) || !sc.matchesRegexp(0, 0, 1) || sc.matchesRegexp(0, 1, 1) {
+		t.Errorf("expected each regexp to match only its own stream")
+	}
+
+	rules := []Rule{
+		{Name: "a", Strings: []StringMatcher{{Text: "she"}}, Regexps: []RegexpMatcher{{Regexp: regexp.MustCompile(`(?im)^HERS$`)}}},
+		{Name: "b", Strings: []StringMatcher{{Scope: StderrStream, Text: "he"}}},
+	}
+	if set, err = newMatcherSet(rules); err != nil {
+		t.Fatal(err)
+	}
+	sc = set.scan(MatchWindow{}, commandOutcome(1, 0, "x\nhers\n", "the"))
+	for _, test := range []struct {
+		rule     int
+		expected string
+	}{
+		{0, `rule "a" regexp_matches "(?im)^HERS$" on stdout`},
+		{1, `rule "b" string_matches "he" on stderr`},
+	} {
+		if description, ok := rules[test.rule].match(sc, test.rule); !ok || description != test.expected {
+			t.Errorf("expected %q, got %q", test.expected, description)
+		}
+	}
+}
+
+func TestJSONMatchers(t *testing.T) {
+	matchers, err := ParseJSONMatchers(`error.code == 429, .status in ["A","B"], stderr:items[0].name, "count >= 2", .kind != ""`)
+	if err != nil || len(matchers) != 5 {
+		t.Fatalf("unexpected matchers %v: %v", matchers, err)
+	}
+	if matchers[2].Scope != StderrStream || len(matchers[2].Path) != 3 || matchers[2].Operator != "" {
+		t.Errorf("unexpected matcher %+v", matchers[2])
+	}
+	for _, test := range []struct {
+		matcher  int
+		text     string
+		expected bool
+	}{
+		{0, `{"error": {"code": 429}}`, true},
+		{0, `{"error": {"code": 500}}`, false},
+		{0, "starting\n{\"error\": {\"code\": 500}}\n{\"error\": {\"code\": 429}}\ndone\n", true},
+		{1, `{"status": "B"}`, true},
+		{1, `{"status": "C"}`, false},
+		{2, `{"items": [{"name": "x"}]}`, true},
+		{2, `{"items": []}`, false},
+		{3, `{"count": 2}`, true},
+		{3, `{"count": "2"}`, false},
+		{4, `{"kind": "Pod"}`, true},
+		{4, `not json`, false},
+	} {
+		if matched := matchers[test.matcher].matches(parseDocuments(test.text)); matched != test.expected {
+			t.Errorf("expected %s against %q to be %v", matchers[test.matcher], test.text, test.expected)
+		}
+	}
+	for _, bad := range []string{"error.code ==", ".status in A", "error code"} {
+		if _, err := ParseJSONMatchers(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+
+	policy := NewPolicy()
+	policy.Retries = 1
+	policy.RetryOnJSON, _ = ParseJSONMatchers("error.code == 429")
+	result := Run(context.Background(), policy, []string{"sh", "-c", `echo '{"error": {"code": 429}}'; exit 1`})
+	if len(result.Attempts) != 2 || result.Rule != `retry_on_json_matches "error.code == 429" on stdout` {
+		t.Errorf("expected the JSON to retry, got %d attempts from %q", len(result.Attempts), result.Rule)
+	}
+}
+
+func TestRunConditions(t *testing.T) {
+	policy := NewPolicy()
+	policy.Retries = 1
+	policy.RetryIf = `exit_code == 1 && stdout_contains("again")`
+	result := Run(context.Background(), policy, []string{"sh", "-c", "echo try again; exit 1"})
+	if len(result.Attempts) != 2 || result.Rule != `retry_if "exit_code == 1 && stdout_contains(\"again\")"` {
+		t.Errorf("expected the condition to retry, got %d attempts from %q", len(result.Attempts), result.Rule)
+	}
+	result = Run(context.Background(), policy, []string{"sh", "-c", "echo try again; exit 2"})
+	if len(result.Attempts) != 1 || result.Reason != Failed {
+		t.Errorf("expected a false condition not to retry, got %s after %d attempts", result.Reason, len(result.Attempts))
+	}
+
+	rule, err := ParseRuleSpec(`name=long exit_codes=0 fail_if="stdout_lines > 2 || stderr_matches('^fatal')"`)
+	if err != nil || rule.Action != Fail {
+		t.Fatalf("unexpected rule %v: %v", rule, err)
+	}
+	policy = NewPolicy()
+	policy.Rules = []Rule{rule}
+	result = Run(context.Background(), policy, []string{"sh", "-c", "echo a; echo b; echo c"})
+	if result.ExitCode != ForcedFailureExitCode || result.Rule != `rule "long" exit_codes "0", fail_if "stdout_lines > 2 || stderr_matches('^fatal')"` {
+		t.Errorf("expected the rule to fail the command, got %d from %q", result.ExitCode, result.Rule)
+	}
+	if result = Run(context.Background(), policy, []string{"echo", "a"}); result.ExitCode != 0 {
+		t.Errorf("expected a false condition to leave the command successful, got %d from %q", result.ExitCode, result.Rule)
+	}
+
+	// fail_if stops retrying, even with retry_on_all, as it does in a rule
+	policy = NewPolicy()
+	policy.Retries = 2
+	policy.RetryOnAll = true
+	policy.FailIf = "exit_code == 0"
+	result = Run(context.Background(), policy, []string{"true"})
+	if len(result.Attempts) != 1 || result.Reason != FailOnMatch || result.ExitCode != ForcedFailureExitCode || result.Rule != `fail_if "exit_code == 0"` {
+		t.Errorf("expected fail_if to stop retrying, got %s with %d after %d attempts from %q", result.Reason, result.ExitCode, len(result.Attempts), result.Rule)
+	}
+
+	for _, bad := range []string{"unknown > 1", "exit_code + 1", "stdout_has('x')"} {
+		policy = NewPolicy()
+		policy.SucceedIf = bad
+		var formulaErr *FormulaError
+		if result = Run(context.Background(), policy, []string{"true"}); result.Reason != ExpressionError || !errors.As(result.Err, &formulaErr) {
+			t.Errorf("expected %q to be rejected before running, got %s: %v", bad, result.Reason, result.Err)
+		}
+	}
+	for _, spec := range []string{"retry_if=true fail_if=true", "retry_if=true action=fail"} {
+		if _, err := ParseRuleSpec(spec); err == nil {
+			t.Errorf("expected %q to be rejected", spec)
+		}
+	}
+}
+
+func TestRunSignals(t *testing.T) {
+	policy := NewPolicy()
+	policy.Retries = 1
+	policy.RetryOnSignals, _ = ParseSignals("kill,SIGSEGV")
+
+	result := Run(context.Background(), policy, []string{"sh", "-c", "kill -KILL $$"})
+	if len(result.Attempts) != 2 || result.Reason != RetriesExhausted || result.Rule != `retry_on_signals "SIGKILL"` {
+		t.Errorf("expected SIGKILL to be retried, got %s after %d attempts from %q", result.Reason, len(result.Attempts), result.Rule)
+	}
+	if attempt := result.Attempts[0]; attempt.Signal != syscall.SIGKILL || attempt.ExitCode != 137 || result.ExitCode != 137 {
+		t.Errorf("expected SIGKILL to give exit code 137, got %d (%d from %s)", result.ExitCode, attempt.ExitCode, SignalName(attempt.Signal))
+	}
+
+	result = Run(context.Background(), policy, []string{"sh", "-c", "kill -TERM $$"})
+	if len(result.Attempts) != 1 || result.Reason != Failed || result.ExitCode != 143 {
+		t.Errorf("expected SIGTERM to fail with 143, got %s with %d", result.Reason, result.ExitCode)
+	}
+
+	// A forced failure is told apart by its reason, not by a made up exit code
+	policy.RetryOnSignals = nil
+	policy.FailOnStrings, _ = ParseStringMatchers("fatal")
+	result = Run(context.Background(), policy, []string{"sh", "-c", "echo fatal; kill -KILL $$"})
+	if result.Reason != FailOnMatch || result.ExitCode != ForcedFailureExitCode || result.Attempts[0].ExitCode != 137 {
+		t.Errorf("expected fail_on to force a failure, got %s with %d", result.Reason, result.ExitCode)
+	}
+
+	if _, err := ParseSignals("SIGNOPE"); err == nil {
+		t.Errorf("expected an unknown signal to be rejected")
+	}
+}
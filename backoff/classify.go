@@ -0,0 +1,195 @@
+/*
+* Copyright 2020-present, Synopsys, Inc. * All rights reserved.
+*
+* This source code is licensed under the Apache-2.0 license found in
+* the LICENSE file in the root directory of this source tree. */
+package backoff
+
+import (
+	"fmt"
+)
+
+// AllRules returns every rule an attempt is classified by, in the order
+// they are tried: Rules, followed by a rule for each of the older matcher
+// settings that is set. Those come in the order success_on, succeed_if,
+// fail_on, fail_if, fail_unless, success_on_exit_codes, retry_on and
+// retry_if. fail_on and fail_unless are fail-continue rules, so the
+// retry_on settings can still retry what they fail. fail_if stops retrying,
+// as it does in a rule. success_on_exit_codes comes after the fail rules,
+// so the command's exit code never turns their failure into a success.
+func (p Policy) AllRules() []Rule {
+	rules := append([]Rule(nil), p.Rules...)
+	add := func(r Rule) {
+		r.setting = true
+		rules = append(rules, r)
+	}
+
+	if len(p.SuccessOnErrors) > 0 {
+		add(Rule{Name: "success_on_errors", Errors: p.SuccessOnErrors, Action: Succeed})
+	}
+	if len(p.SuccessOnStrings) > 0 {
+		add(Rule{Name: "success_on_string_matches", Strings: p.SuccessOnStrings, Action: Succeed})
+	}
+	if len(p.SuccessOnRegexps) > 0 {
+		add(Rule{Name: "success_on_regexp_matches", Regexps: p.SuccessOnRegexps, Action: Succeed})
+	}
+	if len(p.SuccessOnJSON) > 0 {
+		add(Rule{Name: "success_on_json_matches", JSON: p.SuccessOnJSON, Action: Succeed})
+	}
+	if p.SucceedIf != "" {
+		add(Rule{Name: "succeed_if", If: p.SucceedIf, Action: Succeed})
+	}
+
+	if len(p.FailOnStrings) > 0 {
+		add(Rule{Name: "fail_on_string_matches", Strings: p.FailOnStrings, Action: FailContinue})
+	}
+	if len(p.FailOnRegexps) > 0 {
+		add(Rule{Name: "fail_on_regexp_matches", Regexps: p.FailOnRegexps, Action: FailContinue})
+	}
+	if len(p.FailOnJSON) > 0 {
+		add(Rule{Name: "fail_on_json_matches", JSON: p.FailOnJSON, Action: FailContinue})
+	}
+	if p.FailIf != "" {
+		add(Rule{Name: "fail_if", If: p.FailIf, Action: Fail})
+	}
+
+	// A fail_unless match is a success, and anything else a failure. Once
+	// fail_on has failed the attempt, a fail_unless match is skipped.
+	if len(p.FailUnlessStrings) > 0 {
+		add(Rule{Name: "fail_unless_string_matches", Strings: p.FailUnlessStrings, Action: Succeed})
+	}
+	if len(p.FailUnlessRegexps) > 0 {
+		add(Rule{Name: "fail_unless_regexp_matches", Regexps: p.FailUnlessRegexps, Action: Succeed})
+	}
+	if len(p.FailUnlessStrings) > 0 || len(p.FailUnlessRegexps) > 0 {
+		name := "fail_unless_string_matches"
+		if len(p.FailUnlessStrings) == 0 {
+			name = "fail_unless_regexp_matches"
+		}
+		add(Rule{Name: name, Strings: p.FailUnlessStrings, Regexps: p.FailUnlessRegexps, Unless: true, Action: FailContinue})
+	}
+	if len(p.SuccessOnExitCodes) > 0 {
+		add(Rule{Name: "success_on_exit_codes", ExitCodes: p.SuccessOnExitCodes, Action: Succeed})
+	}
+
+	if p.RetryOnAll {
+		add(Rule{Name: "retry_on_all", OnlyOnFailure: true, Action: Retry})
+	}
+	if len(p.RetryOnErrors) > 0 {
+		add(Rule{Name: "retry_on_errors", Errors: p.RetryOnErrors, OnlyOnFailure: true, Action: Retry})
+	}
+	if len(p.RetryOnExitCodes) > 0 {
+		add(Rule{Name: "retry_on_exit_codes", ExitCodes: p.RetryOnExitCodes, OnlyOnFailure: true, Action: Retry})
+	}
+	if len(p.RetryOnSignals) > 0 {
+		add(Rule{Name: "retry_on_signals", Signals: p.RetryOnSignals, OnlyOnFailure: true, Action: Retry})
+	}
+	if len(p.RetryOnStrings) > 0 {
+		add(Rule{Name: "retry_on_string_matches", Strings: p.RetryOnStrings, OnlyOnFailure: true, Action: Retry})
+	}
+	if len(p.RetryOnRegexps) > 0 {
+		add(Rule{Name: "retry_on_regexp_matches", Regexps: p.RetryOnRegexps, OnlyOnFailure: true, Action: Retry})
+	}
+	if len(p.RetryOnJSON) > 0 {
+		add(Rule{Name: "retry_on_json_matches", JSON: p.RetryOnJSON, OnlyOnFailure: true, Action: Retry})
+	}
+	if p.RetryIf != "" {
+		add(Rule{Name: "retry_if", If: p.RetryIf, OnlyOnFailure: true, Action: Retry})
+	}
+	return rules
+}
+
+// verdict is what the rules made of an attempt
+type verdict struct {
+	// The index of the rule that decided, -1 when none matched
+	rule        int
+	action      Action
+	description string
+	// Whether a fail-continue rule, or a setting such as fail_if, forced a
+	// failure, which exits with ForcedFailureExitCode
+	forced bool
+	// A condition that could not be evaluated, which stops the retries
+	err error
+}
+
+// classify tries rules against an attempt in order. The first to match,
+// other than a Continue or FailContinue rule, decides what happens to it.
+// Once a FailContinue rule has matched, the Succeed rules after it are
+// skipped, and if nothing else decides the attempt fails. When no rule
+// matches, an attempt that exited with 0 succeeds and any other fails. The
+// matchers only see the end of the output given by Policy.MatchWindow. A
+// rule's condition is only evaluated once its matchers have matched.
+func classify(policy Policy, rules []Rule, set *matcherSet, attempt int, o outcome) verdict {
+	sc := set.scan(policy.MatchWindow, o)
+	// The first FailContinue rule to match, which decides if nothing after it does
+	forced := verdict{rule: -1, action: Fail, forced: true}
+	for i, r := range rules {
+		if sc.forced && r.Action == Succeed {
+			continue
+		}
+		description, ok := r.match(sc, i)
+		if ok && set.conditions[i] != nil {
+			holds, err := set.conditions[i].holds(sc, attempt)
+			if err != nil {
+				return verdict{rule: i, action: Fail, description: r.describeIf(description), err: err}
+			}
+			ok, description = holds, r.describeIf(description)
+		}
+		if !ok {
+			continue
+		}
+		log.Debug("Attempt ", attempt, " matched ", description, ". Action: ", r.Action)
+		explainf(policy, "Attempt %d: %s -> %s\n", attempt, description, r.Action)
+		switch r.Action {
+		case Continue:
+		case FailContinue:
+			if forced.rule < 0 {
+				forced.rule, forced.description = i, description
+				sc.force()
+			}
+		default:
+			return verdict{rule: i, action: r.Action, description: description, forced: sc.forced || (r.setting && r.Action == Fail)}
+		}
+	}
+
+	if forced.rule >= 0 {
+		explainf(policy, "Attempt %d: no later rule matched -> %s\n", attempt, Fail)
+		return forced
+	}
+	v := verdict{rule: -1, action: Fail}
+	if !o.failed() {
+		v.action = Succeed
+	}
+	if o.signal != 0 {
+		explainf(policy, "Attempt %d: no rule matched %s -> %s\n", attempt, SignalName(o.signal), v.action)
+	} else if o.hasExitCode {
+		explainf(policy, "Attempt %d: no rule matched exit code %d -> %s\n", attempt, o.exitCode, v.action)
+	} else {
+		explainf(policy, "Attempt %d: no rule matched the error -> %s\n", attempt, v.action)
+	}
+	return v
+}
+
+// explainRules lists the rules for --explain, in the order they are tried
+func explainRules(policy Policy, rules []Rule) {
+	explainf(policy, "Rules, tried in order:\n")
+	for i, r := range rules {
+		explainf(policy, "  %d. %s\n", i+1, r)
+	}
+	explainf(policy, "  Otherwise: succeed on exit code 0, and fail on any other\n")
+}
+
+func explainf(policy Policy, format string, a ...interface{}) {
+	if policy.Explain {
+		fmt.Fprintf(policy.stderr(), format, a...)
+	}
+}
+
+// describe names the setting, and the stream it matched on, that
+// classified an attempt
+func describe(key string, value interface{}, stream string) string {
+	if stream == "" {
+		return fmt.Sprintf("%s %q", key, fmt.Sprint(value))
+	}
+	return fmt.Sprintf("%s %q on %s", key, fmt.Sprint(value), stream)
+}
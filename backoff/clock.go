@@ -0,0 +1,52 @@
+/*
+* Copyright 2020-present, Synopsys, Inc. * All rights reserved.
+*
+* This source code is licensed under the Apache-2.0 license found in
+* the LICENSE file in the root directory of this source tree. */
+package backoff
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Clock is how the retry loop tells the time and waits between attempts.
+// Replacing it lets the loop be tested, or simulated, without waiting.
+// Attempt timeouts are still timed by the real clock, as they have to stop
+// a real command.
+type Clock interface {
+	Now() time.Time
+	// Sleep waits for d, returning false if ctx is done first
+	Sleep(ctx context.Context, d time.Duration) bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) Sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (p Policy) clock() Clock {
+	if p.Clock != nil {
+		return p.Clock
+	}
+	return realClock{}
+}
+
+// random is where the expression's 'r' and rand() come from
+func (p Policy) random() *rand.Rand {
+	if p.Random != nil {
+		return rand.New(p.Random)
+	}
+	return rand.New(rand.NewSource(p.clock().Now().UnixNano()))
+}
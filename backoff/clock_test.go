@@ -0,0 +1,305 @@
+/*
+* Copyright 2020-present, Synopsys, Inc. * All rights reserved.
+*
+* This source code is licensed under the Apache-2.0 license found in
+* the LICENSE file in the root directory of this source tree. */
+
+package backoff
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeClock only moves when it is slept on or advanced, so the loop can be
+// run through hours of backoff in no time
+type fakeClock struct {
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Sleep(ctx context.Context, d time.Duration) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	c.sleeps = append(c.sleeps, d)
+	c.now = c.now.Add(d)
+	return true
+}
+
+var errFlaky = errors.New("flaky")
+
+// failFor returns a function for Do that fails, taking d on the clock each
+// time
+func failFor(clock *fakeClock, d time.Duration, calls *int) func(context.Context) (int, error) {
+	return func(ctx context.Context) (int, error) {
+		*calls++
+		clock.now = clock.now.Add(d)
+		return 0, errFlaky
+	}
+}
+
+func expectSleeps(t *testing.T, clock *fakeClock, expected ...time.Duration) {
+	t.Helper()
+	if len(clock.sleeps) != len(expected) {
+		t.Fatalf("expected sleeps of %v, got %v", expected, clock.sleeps)
+	}
+	for i := range expected {
+		if clock.sleeps[i] != expected[i] {
+			t.Fatalf("expected sleeps of %v, got %v", expected, clock.sleeps)
+		}
+	}
+}
+
+func TestClockRetries(t *testing.T) {
+	clock := newFakeClock()
+	policy := NewPolicy()
+	policy.Clock = clock
+	policy.Expression = "3600*i"
+	policy.Retries = 3
+	policy.RetryOnAll = true
+
+	calls := 0
+	_, err := Do(context.Background(), policy, failFor(clock, time.Second, &calls))
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || retryErr.Reason != RetriesExhausted || calls != 4 {
+		t.Fatalf("expected retries to be exhausted after 4 calls, got %v after %d calls", err, calls)
+	}
+	expectSleeps(t, clock, time.Hour, 2*time.Hour, 3*time.Hour)
+	if attempts := retryErr.Attempts; attempts[3].Start.Sub(attempts[0].Start) != 6*time.Hour+3*time.Second {
+		t.Errorf("expected the last attempt to start 6h3s after the first, got %s", attempts[3].Start.Sub(attempts[0].Start))
+	}
+}
+
+func TestClockDuration(t *testing.T) {
+	clock := newFakeClock()
+	policy := NewPolicy()
+	policy.Clock = clock
+	policy.Expression = "20"
+	policy.Duration = time.Minute
+	policy.RetryOnAll = true
+
+	// Attempts take 5s: 0-5, wait 20, 25-30, wait 20, 50-55, then the wait
+	// is cut to 5s so the last attempt starts as the duration runs out
+	calls := 0
+	_, err := Do(context.Background(), policy, failFor(clock, 5*time.Second, &calls))
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || retryErr.Reason != DurationExhausted || calls != 4 {
+		t.Fatalf("expected the duration to be exhausted after 4 calls, got %v after %d calls", err, calls)
+	}
+	expectSleeps(t, clock, 20*time.Second, 20*time.Second, 5*time.Second)
+}
+
+func TestClockOverrun(t *testing.T) {
+	clock := newFakeClock()
+	policy := NewPolicy()
+	policy.Clock = clock
+	policy.Expression = "pow(10, i)"
+	policy.Duration = 1000 * time.Second
+	policy.RetryOnAll = true
+
+	calls := 0
+	Do(context.Background(), policy, failFor(clock, 0, &calls))
+	// 10 + 100 leaves 890s, which the 1000s wait is cut down to
+	expectSleeps(t, clock, 10*time.Second, 100*time.Second, 890*time.Second)
+	if calls != 4 {
+		t.Errorf("expected 4 calls, got %d", calls)
+	}
+}
+
+func TestClockRun(t *testing.T) {
+	clock := newFakeClock()
+	policy := NewPolicy()
+	policy.Clock = clock
+	policy.Expression = "86400"
+	policy.Retries = 2
+	policy.RetryOnAll = true
+
+	result := Run(context.Background(), policy, []string{"false"})
+	if result.Reason != RetriesExhausted || len(result.Attempts) != 3 {
+		t.Fatalf("expected retries to be exhausted after 3 attempts, got %s after %d", result.Reason, len(result.Attempts))
+	}
+	expectSleeps(t, clock, 24*time.Hour, 24*time.Hour)
+	if result.Elapsed() < 48*time.Hour {
+		t.Errorf("expected the run to take 48h on the clock, got %s", result.Elapsed())
+	}
+}
+
+func TestClockDurationStopsAttempt(t *testing.T) {
+	clock := newFakeClock()
+	policy := NewPolicy()
+	policy.Clock = clock
+	policy.Expression = "7200"
+	policy.Duration = time.Hour
+	policy.DurationStopsAttempt = true
+	policy.RetryOnAll = true
+
+	// The wait is cut to the end of the duration, which leaves no time for
+	// another attempt, so the last one's exit code stands
+	result := Run(context.Background(), policy, []string{"sh", "-c", "exit 3"})
+	if result.Reason != DurationExhausted || result.ExitCode != 3 || len(result.Attempts) != 1 || result.Err != nil {
+		t.Fatalf("expected exit code 3 after 1 attempt, got %d (%s) after %d attempts: %v", result.ExitCode, result.Reason, len(result.Attempts), result.Err)
+	}
+	expectSleeps(t, clock, time.Hour)
+}
+
+func TestRandomSeeded(t *testing.T) {
+	sleeps := func(seed int64) []time.Duration {
+		clock := newFakeClock()
+		policy := NewPolicy()
+		policy.Clock = clock
+		policy.Random = rand.NewSource(seed)
+		policy.Expression = "10*r + rand(0, 10)"
+		policy.Retries = 5
+		policy.RetryOnAll = true
+		calls := 0
+		Do(context.Background(), policy, failFor(clock, 0, &calls))
+		return clock.sleeps
+	}
+	first, second, other := sleeps(42), sleeps(42), sleeps(43)
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("expected the same seed to give the same sleeps, got %v and %v", first, second)
+		}
+	}
+	same := true
+	for i := range first {
+		same = same && first[i] == other[i]
+	}
+	if same {
+		t.Errorf("expected a different seed to give different sleeps, got %v for both", first)
+	}
+}
+
+func TestClockRetryAfter(t *testing.T) {
+	clock := newFakeClock()
+	policy := NewPolicy()
+	policy.Clock = clock
+	policy.Expression = "10"
+	policy.Duration = 10 * time.Minute
+	policy.RetryOnAll = true
+	policy.RetryAfterRegexps, _ = ParseRegexps(`Retry-After: ([^\n]+),retry in (\d+s)`)
+
+	hints := []string{
+		"Retry-After: 30",
+		"please retry in 5s",
+		"Retry-After: " + clock.now.Add(3*time.Minute).Format(http.TimeFormat),
+		"no hint",
+		"Retry-After: 3600",
+		"no hint",
+	}
+	hinted := func(ctx context.Context) (int, error) {
+		err := errors.New(hints[0])
+		hints = append(hints[1:], hints[0])
+		return 0, err
+	}
+	// The hint is a floor: 30s, 10s, until 3m in, 10s, then the hour is cut
+	// short at the duration
+	Do(context.Background(), policy, hinted)
+	expectSleeps(t, clock, 30*time.Second, 10*time.Second, 140*time.Second, 10*time.Second, 410*time.Second)
+
+	clock = newFakeClock()
+	policy.Clock = clock
+	policy.RetryAfterOverrides = true
+	Do(context.Background(), policy, hinted)
+	expectSleeps(t, clock, 30*time.Second, 5*time.Second, 145*time.Second, 10*time.Second, 410*time.Second)
+
+	clock = newFakeClock()
+	policy.Clock = clock
+	policy.RetryAfterOverrides = false
+	policy.Expression = "hint + 1"
+	policy.Retries = 2
+	hints = []string{"Retry-After: 4", "no hint"}
+	Do(context.Background(), policy, hinted)
+	expectSleeps(t, clock, 5*time.Second, 0)
+}
+
+func TestClockRules(t *testing.T) {
+	clock := newFakeClock()
+	policy := NewPolicy()
+	policy.Clock = clock
+	policy.Expression = "1"
+	policy.RetryOnAll = true
+	policy.Rules = []Rule{
+		{Name: "quota", Strings: []StringMatcher{{Text: "quota"}}, Expression: "100*i", Retries: 2},
+		{Name: "busy", Strings: []StringMatcher{{Text: "busy"}}, Expression: "5"},
+	}
+
+	errs := []string{"quota", "busy", "quota", "busy", "other", "quota"}
+	calls := 0
+	_, err := Do(context.Background(), policy, func(ctx context.Context) (int, error) {
+		calls++
+		return 0, errors.New(errs[calls-1])
+	})
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || retryErr.Reason != RetriesExhausted || retryErr.Rule != `rule "quota" string_matches "quota" on error` {
+		t.Fatalf("expected the quota rule to run out of retries, got %v", err)
+	}
+	// Each rule counts its own retries, and "other" falls back to the
+	// policy's expression
+	expectSleeps(t, clock, 100*time.Second, 5*time.Second, 200*time.Second, 5*time.Second, time.Second)
+}
+
+func TestClockConditions(t *testing.T) {
+	clock := newFakeClock()
+	policy := NewPolicy()
+	policy.Clock = clock
+	policy.Expression = "1"
+	policy.RetryIf = "exit_code == 1 && duration < 2 && attempt < 5"
+
+	// Quick failures are retried, and the first slow one is not
+	durations := []time.Duration{time.Second, time.Second, 3 * time.Second, time.Second}
+	calls := 0
+	_, err := Do(context.Background(), policy, func(ctx context.Context) (int, error) {
+		calls++
+		clock.now = clock.now.Add(durations[calls-1])
+		return 0, errFlaky
+	})
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || retryErr.Reason != Failed || calls != 3 {
+		t.Fatalf("expected the slow failure not to be retried, got %v after %d calls", err, calls)
+	}
+	expectSleeps(t, clock, time.Second, time.Second)
+}
+
+// cancelClock cancels the context the first time it is slept on
+type cancelClock struct {
+	*fakeClock
+	cancel context.CancelCauseFunc
+}
+
+func (c cancelClock) Sleep(ctx context.Context, d time.Duration) bool {
+	c.cancel(&SignalError{Signal: syscall.SIGTERM})
+	return c.fakeClock.Sleep(ctx, d)
+}
+
+func TestClockCancelledWhileSleeping(t *testing.T) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	clock := newFakeClock()
+	policy := NewPolicy()
+	policy.Clock = cancelClock{clock, cancel}
+	policy.RetryOnAll = true
+
+	calls := 0
+	_, err := Do(ctx, policy, failFor(clock, 0, &calls))
+	var retryErr *RetryError
+	var signalErr *SignalError
+	if !errors.As(err, &retryErr) || retryErr.Reason != Cancelled || calls != 1 {
+		t.Fatalf("expected the wait to be cancelled after 1 call, got %v after %d calls", err, calls)
+	}
+	if !errors.Is(err, context.Canceled) || errors.Is(err, errFlaky) || !errors.As(err, &signalErr) {
+		t.Errorf("expected the context's cause rather than the last error, got %v", err)
+	}
+}
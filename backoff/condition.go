@@ -0,0 +1,169 @@
+/*
+* Copyright 2020-present, Synopsys, Inc. * All rights reserved.
+*
+* This source code is licensed under the Apache-2.0 license found in
+* the LICENSE file in the root directory of this source tree. */
+package backoff
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Knetic/govaluate"
+)
+
+// condition is a rule's retry_if, succeed_if or fail_if expression,
+// compiled once before the first attempt
+type condition struct {
+	text       string
+	expression *govaluate.EvaluableExpression
+	// The attempt being classified, which the functions look at
+	scan    *scan
+	regexps map[string]*regexp.Regexp
+}
+
+// conditionFunctions are the functions available to conditions. They look
+// at the output of the attempt being classified, cut down to the match
+// window.
+func conditionFunctions(c *condition) map[string]govaluate.ExpressionFunction {
+	contains := func(name string, stream string) govaluate.ExpressionFunction {
+		return func(args ...interface{}) (interface{}, error) {
+			text, err := stringArg(name, args)
+			if err != nil {
+				return nil, err
+			}
+			return strings.Contains(c.scan.output(stream), text), nil
+		}
+	}
+	matches := func(name string, stream string) govaluate.ExpressionFunction {
+		return func(args ...interface{}) (interface{}, error) {
+			expression, err := stringArg(name, args)
+			if err != nil {
+				return nil, err
+			}
+			re, ok := c.regexps[expression]
+			if !ok {
+				if re, err = regexp.Compile(expression); err != nil {
+					return nil, fmt.Errorf("%s(): %w", name, err)
+				}
+				c.regexps[expression] = re
+			}
+			return re.MatchString(c.scan.output(stream)), nil
+		}
+	}
+	return map[string]govaluate.ExpressionFunction{
+		"stdout_contains": contains("stdout_contains", "stdout"),
+		"stderr_contains": contains("stderr_contains", "stderr"),
+		"stdout_matches":  matches("stdout_matches", "stdout"),
+		"stderr_matches":  matches("stderr_matches", "stderr"),
+	}
+}
+
+// ConditionVariables lists the variables available to conditions
+func ConditionVariables() []string {
+	return []string{"attempt", "duration", "exit_code", "stderr_lines", "stdout_lines"}
+}
+
+// ConditionFunctionNames lists the functions available to conditions
+func ConditionFunctionNames() []string {
+	var names []string
+	for name := range conditionFunctions(nil) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// stringArg checks a function was given a single string
+func stringArg(name string, args []interface{}) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("%s() takes 1 argument, got %d", name, len(args))
+	}
+	text, ok := args[0].(string)
+	if !ok {
+		return "", fmt.Errorf("%s() takes a string, got %v", name, args[0])
+	}
+	return text, nil
+}
+
+// compileCondition compiles a condition and evaluates it once against an
+// attempt that exited with 0 and printed nothing, so that a mistake in it
+// is found before the command is run
+func compileCondition(text string) (*condition, error) {
+	c := &condition{text: text, regexps: make(map[string]*regexp.Regexp)}
+	expression, err := govaluate.NewEvaluableExpressionWithFunctions(text, conditionFunctions(c))
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "Undefined function") {
+			err = fmt.Errorf("%v. Available functions are %s", err, strings.Join(ConditionFunctionNames(), ", "))
+		}
+		return nil, &FormulaError{Expression: text, Compile: true, Err: err}
+	}
+	c.expression = expression
+	for _, variable := range expression.Vars() {
+		known := false
+		for _, name := range ConditionVariables() {
+			known = known || variable == name
+		}
+		if !known {
+			err := fmt.Errorf("unknown variable %s. Available variables are %s", variable, strings.Join(ConditionVariables(), ", "))
+			return nil, &FormulaError{Expression: text, Compile: true, Err: err}
+		}
+	}
+	if _, err := c.holds(&scan{outcome: commandOutcome(0, 0, "", "")}, 1); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// holds evaluates the condition against attempt number attempt
+func (c *condition) holds(sc *scan, attempt int) (bool, error) {
+	exitCode := sc.exitCode
+	if !sc.hasExitCode {
+		// Do counts an error as exiting with 1
+		exitCode = 1
+	}
+	parameters := map[string]interface{}{
+		"attempt":      float64(attempt),
+		"duration":     sc.elapsed.Seconds(),
+		"exit_code":    float64(exitCode),
+		"stdout_lines": float64(countLines(sc.output("stdout"))),
+		"stderr_lines": float64(countLines(sc.output("stderr"))),
+	}
+	c.scan = sc
+	defer func() { c.scan = nil }()
+	evaluated, err := c.expression.Evaluate(parameters)
+	if err != nil {
+		return false, &FormulaError{Expression: c.text, Err: err}
+	}
+	holds, ok := evaluated.(bool)
+	if !ok {
+		return false, &FormulaError{Expression: c.text, Err: fmt.Errorf("must be true or false, got %v", evaluated)}
+	}
+	return holds, nil
+}
+
+// output is the text of the named stream, cut down to the match window.
+// Errors returned to Do have neither stdout nor stderr.
+func (sc *scan) output(name string) string {
+	for _, st := range sc.streams {
+		if st.name == name {
+			return st.text
+		}
+	}
+	return ""
+}
+
+// countLines counts the lines in text. A trailing newline does not start
+// another line.
+func countLines(text string) int {
+	if text == "" {
+		return 0
+	}
+	n := strings.Count(text, "\n")
+	if !strings.HasSuffix(text, "\n") {
+		n++
+	}
+	return n
+}
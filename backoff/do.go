@@ -0,0 +1,204 @@
+/*
+* Copyright 2020-present, Synopsys, Inc. * All rights reserved.
+*
+* This source code is licensed under the Apache-2.0 license found in
+* the LICENSE file in the root directory of this source tree. */
+package backoff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrorMatcher reports whether an error returned to Do matches
+type ErrorMatcher func(error) bool
+
+// ErrorIs matches errors that wrap target, as reported by errors.Is
+func ErrorIs(target error) ErrorMatcher {
+	return func(err error) bool {
+		return errors.Is(err, target)
+	}
+}
+
+// ErrorAs matches errors that wrap an error of type E, as reported by
+// errors.As
+func ErrorAs[E error]() ErrorMatcher {
+	return func(err error) bool {
+		var target E
+		return errors.As(err, &target)
+	}
+}
+
+// RetryError is returned by Do when it gives up. It wraps the error
+// returned by the final call, so errors.Is and errors.As see through it.
+type RetryError struct {
+	Reason   Reason
+	Attempts []Attempt
+	// The setting that decided the final classification
+	Rule string
+	Err  error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("giving up after %d attempts (%s): %v", len(e.Attempts), e.Reason, e.Err)
+}
+
+func (e *RetryError) Unwrap() error { return e.Err }
+
+// Do calls fn, retrying it as described by policy, and returns the value
+// from the final call.
+//
+// The error returned by fn is classified by the same rules as Run
+// classifies a command's output, tried in the order Policy.AllRules
+// gives: string and regexp matchers are matched against err.Error(), and
+// RetryOnErrors, SuccessOnErrors and Rule.Errors against the error itself.
+// RetryOnAll retries every error. Settings that only make sense for a
+// process, such as exit codes and metrics, are ignored. The context passed
+// to fn carries Policy.AttemptTimeout, and an error returned once it has
+// expired is classified as a timeout.
+//
+// When fn succeeds, or its error matches a success setting, Do returns a
+// nil error. Otherwise it returns a *RetryError. A failing perform on exit
+// command is reported as a *HookError.
+func Do[T any](ctx context.Context, policy Policy, fn func(context.Context) (T, error)) (T, error) {
+	value, result, err := do(ctx, policy, fn)
+	if policy.PerformOnExit != "" {
+		if hookErr := catchFailure("Exit", policy.PerformOnExit, resultEnv(policy, result)); hookErr != nil {
+			log.Error(hookErr)
+			if err == nil {
+				err = hookErr
+			}
+		}
+	}
+	return value, err
+}
+
+func do[T any](ctx context.Context, policy Policy, fn func(context.Context) (T, error)) (T, Result, error) {
+	result := Result{RunID: newRunID()}
+	var value T
+	var err error
+	// A nil cause is ctx's, along with the cause it was cancelled with
+	giveUp := func(reason Reason, rule string, cause error) (T, Result, error) {
+		if cause == nil {
+			cause = ctx.Err()
+			if c := context.Cause(ctx); c != nil && c != cause {
+				cause = fmt.Errorf("%w: %w", c, cause)
+			}
+		}
+		result.Reason = reason
+		result.Rule = rule
+		return value, result, &RetryError{Reason: reason, Attempts: result.Attempts, Rule: rule, Err: cause}
+	}
+
+	random := policy.random()
+	formula, formulaErr := compileFormula(policy, random)
+	if formulaErr != nil {
+		return giveUp(ExpressionError, "", formulaErr)
+	}
+	allRules := policy.AllRules()
+	rules, formulaErr := compileRules(policy, allRules, random)
+	if formulaErr != nil {
+		return giveUp(ExpressionError, "", formulaErr)
+	}
+	matchers, formulaErr := newMatcherSet(allRules)
+	if formulaErr != nil {
+		return giveUp(ExpressionError, "", formulaErr)
+	}
+	explainRules(policy, allRules)
+
+	clock := policy.clock()
+	xIncrement := 0
+	start := clock.Now()
+	for {
+		if ctx.Err() != nil {
+			return giveUp(Cancelled, "", nil)
+		}
+
+		attemptStart := clock.Now()
+		attemptCtx, cancelAttempt := ctx, context.CancelFunc(func() {})
+		if policy.AttemptTimeout > 0 {
+			attemptCtx, cancelAttempt = context.WithTimeout(ctx, policy.AttemptTimeout)
+		}
+		value, err = fn(attemptCtx)
+		timedOut := ctx.Err() == nil && attemptCtx.Err() != nil
+		cancelAttempt()
+		exitCode := 0
+		if err != nil {
+			exitCode = 1
+		}
+		result.Attempts = append(result.Attempts, Attempt{
+			Number:   xIncrement + 1,
+			Start:    attemptStart,
+			Elapsed:  clock.Now().Sub(attemptStart),
+			ExitCode: exitCode,
+			TimedOut: timedOut,
+		})
+		if err == nil {
+			return value, result, nil
+		}
+		log.Debug("Function returned error: ", err)
+
+		if ctx.Err() != nil {
+			return giveUp(Cancelled, "", err)
+		}
+
+		matched, rule, action := -1, "attempt_timeout", Fail
+		if timedOut {
+			if policy.RetryOnTimeout || policy.RetryOnAll {
+				action = Retry
+			}
+			explainf(policy, "Attempt %d: %s -> %s\n", xIncrement+1, rule, action)
+			if action != Retry {
+				return giveUp(TimedOut, rule, err)
+			}
+		} else {
+			o := errorOutcome(err)
+			o.elapsed = result.Attempts[len(result.Attempts)-1].Elapsed
+			v := classify(policy, allRules, matchers, xIncrement+1, o)
+			if v.err != nil {
+				return giveUp(ExpressionError, v.description, v.err)
+			}
+			matched, rule, action = v.rule, v.description, v.action
+		}
+		switch {
+		case action == Succeed:
+			log.Debug("Error matched ", rule, ". Treating as success.")
+			result.Rule = rule
+			return value, result, nil
+		case action == Fail && matched >= 0:
+			return giveUp(FailOnMatch, rule, err)
+		case action == Fail:
+			return giveUp(Failed, rule, err)
+		}
+		log.Debug("Error matched ", rule, ". Restarting.")
+
+		xIncrement++
+		if reason, done := exhausted(policy, xIncrement, clock.Now().Sub(start)); done {
+			log.Warning("Failed to complete function:", reason)
+			return giveUp(reason, rule, err)
+		}
+
+		ruleFormula, ruleIncrement, ok := rules.retry(allRules, matched, formula, xIncrement)
+		if !ok {
+			return giveUp(RetriesExhausted, rule, err)
+		}
+		state := newLoopState(clock.Now().Sub(start), ruleIncrement, result.Attempts, exitCode)
+		state.hint, state.hinted = retryAfter(policy, clock.Now(), err.Error())
+		sleepForD, formulaErr := ruleFormula.nextSleep(policy, state)
+		if formulaErr != nil {
+			return giveUp(ExpressionError, rule, formulaErr)
+		}
+		result.Attempts[len(result.Attempts)-1].Sleep = sleepForD
+		if !clock.Sleep(ctx, sleepForD) {
+			return giveUp(Cancelled, rule, nil)
+		}
+
+		if policy.PerformOnFailure != "" {
+			if hookErr := catchFailure("Failure", policy.PerformOnFailure, loopEnv(policy, result.RunID, start, xIncrement, previousExitCode(result))); hookErr != nil {
+				log.Error(hookErr)
+				return giveUp(HookFailed, rule, hookErr)
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+/*
+* Copyright 2020-present, Synopsys, Inc. * All rights reserved.
+*
+* This source code is licensed under the Apache-2.0 license found in
+* the LICENSE file in the root directory of this source tree. */
+package backoff
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"strconv"
+	"time"
+)
+
+// newRunID returns a random identifier shared by every attempt of one run
+func newRunID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+// loopEnv returns the environment for an attempt, or for a hook run after
+// one, with EB_ variables describing where the retry loop is up to.
+// previousExitCode is empty before the first attempt has finished.
+//
+//	EB_ATTEMPT             the attempt number, 1 based
+//	EB_ATTEMPT_ZERO_BASED  the attempt number, 0 based
+//	EB_MAX_RETRIES         the maximum number of retries, -1 for unlimited
+//	EB_DEADLINE            when the duration runs out (RFC3339), empty for unlimited
+//	EB_REMAINING_SECONDS   seconds until the duration runs out, -1 for unlimited
+//	EB_RUN_ID              an identifier shared by every attempt of this run
+//	EB_PREVIOUS_EXIT_CODE  the exit code of the previous attempt
+func loopEnv(policy Policy, runID string, start time.Time, attempt int, previousExitCode string) []string {
+	deadline := ""
+	remaining := -1
+	if policy.Duration >= 0 {
+		end := start.Add(policy.Duration)
+		deadline = end.Format(time.RFC3339)
+		remaining = int(end.Sub(policy.clock().Now()).Seconds())
+		if remaining < 0 {
+			remaining = 0
+		}
+	}
+	return append(os.Environ(),
+		"EB_ATTEMPT="+strconv.Itoa(attempt),
+		"EB_ATTEMPT_ZERO_BASED="+strconv.Itoa(attempt-1),
+		"EB_MAX_RETRIES="+strconv.Itoa(policy.Retries),
+		"EB_DEADLINE="+deadline,
+		"EB_REMAINING_SECONDS="+strconv.Itoa(remaining),
+		"EB_RUN_ID="+runID,
+		"EB_PREVIOUS_EXIT_CODE="+previousExitCode,
+	)
+}
+
+// resultEnv is the environment for the perform on exit command
+func resultEnv(policy Policy, result Result) []string {
+	start := policy.clock().Now()
+	previousExitCode := ""
+	if len(result.Attempts) > 0 {
+		start = result.Attempts[0].Start
+		previousExitCode = strconv.Itoa(result.Attempts[len(result.Attempts)-1].ExitCode)
+	}
+	return loopEnv(policy, result.RunID, start, len(result.Attempts), previousExitCode)
+}
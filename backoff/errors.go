@@ -0,0 +1,109 @@
+/*
+* Copyright 2020-present, Synopsys, Inc. * All rights reserved.
+*
+* This source code is licensed under the Apache-2.0 license found in
+* the LICENSE file in the root directory of this source tree. */
+package backoff
+
+import "fmt"
+
+// ListError is returned when a comma delimited list cannot be read
+type ListError struct {
+	List string
+	Err  error
+}
+
+func (e *ListError) Error() string {
+	return fmt.Sprintf("unable to read list %q: %v", e.List, e.Err)
+}
+
+func (e *ListError) Unwrap() error { return e.Err }
+
+// ValueError is returned when a setting is given a value it does not
+// understand
+type ValueError struct {
+	Setting  string
+	Value    string
+	Expected string
+}
+
+func (e *ValueError) Error() string {
+	return fmt.Sprintf("invalid %s %q, expected %s", e.Setting, e.Value, e.Expected)
+}
+
+// ExitCodeError is returned when a list of exit codes contains something
+// that is not an integer
+type ExitCodeError struct {
+	List string
+	Err  error
+}
+
+func (e *ExitCodeError) Error() string {
+	return fmt.Sprintf("invalid exit code list %q: %v", e.List, e.Err)
+}
+
+func (e *ExitCodeError) Unwrap() error { return e.Err }
+
+// RegexpError is returned when a regular expression cannot be compiled
+type RegexpError struct {
+	Regexp string
+	Err    error
+}
+
+func (e *RegexpError) Error() string {
+	return fmt.Sprintf("invalid regular expression %q: %v", e.Regexp, e.Err)
+}
+
+func (e *RegexpError) Unwrap() error { return e.Err }
+
+// CommandError is returned when a command string cannot be split into
+// arguments
+type CommandError struct {
+	Command string
+	Err     error
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("unable to parse command %q: %v", e.Command, e.Err)
+}
+
+func (e *CommandError) Unwrap() error { return e.Err }
+
+// HookError is returned when a perform on failure or perform on exit
+// command cannot be parsed, cannot be started, or exits with a non-zero
+// exit code
+type HookError struct {
+	// "Failure" or "Exit"
+	Hook     string
+	Command  string
+	ExitCode int
+	Err      error
+}
+
+func (e *HookError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("unable to run perform on %s command %q: %v", e.Hook, e.Command, e.Err)
+	}
+	return fmt.Sprintf("perform on %s command %q exited with %d", e.Hook, e.Command, e.ExitCode)
+}
+
+func (e *HookError) Unwrap() error { return e.Err }
+
+// FormulaError is returned when the backoff expression cannot be compiled
+// or evaluated
+type FormulaError struct {
+	Expression string
+	// Whether the expression failed to compile, as opposed to failing to
+	// evaluate
+	Compile bool
+	Err     error
+}
+
+func (e *FormulaError) Error() string {
+	if e.Compile {
+		return fmt.Sprintf("formula %q cannot be evaluated: %v", e.Expression, e.Err)
+	}
+	return fmt.Sprintf("formula %q failed to be evaluated: %v", e.Expression, e.Err)
+}
+
+func (e *FormulaError) Unwrap() error { return e.Err }
@@ -0,0 +1,125 @@
+/*
+* Copyright 2020-present, Synopsys, Inc. * All rights reserved.
+*
+* This source code is licensed under the Apache-2.0 license found in
+* the LICENSE file in the root directory of this source tree. */
+package backoff
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/Knetic/govaluate"
+)
+
+// expressionFunctions are the functions available to the expression.
+// random returns a float from 0-1, and is what rand(a, b) and 'r' share.
+func expressionFunctions(random func() float64) map[string]govaluate.ExpressionFunction {
+	return map[string]govaluate.ExpressionFunction{
+		"min": func(args ...interface{}) (interface{}, error) {
+			values, err := numericArgs("min", args, 1, -1)
+			if err != nil {
+				return nil, err
+			}
+			result := values[0]
+			for _, v := range values[1:] {
+				result = math.Min(result, v)
+			}
+			return result, nil
+		},
+		"max": func(args ...interface{}) (interface{}, error) {
+			values, err := numericArgs("max", args, 1, -1)
+			if err != nil {
+				return nil, err
+			}
+			result := values[0]
+			for _, v := range values[1:] {
+				result = math.Max(result, v)
+			}
+			return result, nil
+		},
+		"pow": func(args ...interface{}) (interface{}, error) {
+			values, err := numericArgs("pow", args, 2, 2)
+			if err != nil {
+				return nil, err
+			}
+			return math.Pow(values[0], values[1]), nil
+		},
+		"exp": func(args ...interface{}) (interface{}, error) {
+			values, err := numericArgs("exp", args, 1, 1)
+			if err != nil {
+				return nil, err
+			}
+			return math.Exp(values[0]), nil
+		},
+		"floor": func(args ...interface{}) (interface{}, error) {
+			values, err := numericArgs("floor", args, 1, 1)
+			if err != nil {
+				return nil, err
+			}
+			return math.Floor(values[0]), nil
+		},
+		"ceil": func(args ...interface{}) (interface{}, error) {
+			values, err := numericArgs("ceil", args, 1, 1)
+			if err != nil {
+				return nil, err
+			}
+			return math.Ceil(values[0]), nil
+		},
+		"rand": func(args ...interface{}) (interface{}, error) {
+			values, err := numericArgs("rand", args, 2, 2)
+			if err != nil {
+				return nil, err
+			}
+			return values[0] + random()*(values[1]-values[0]), nil
+		},
+	}
+}
+
+// FunctionNames lists the functions available to the expression
+func FunctionNames() []string {
+	var names []string
+	for name := range expressionFunctions(nil) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// numericArgs checks a function was given between min and max numbers.
+// A max of -1 allows any number of arguments.
+func numericArgs(name string, args []interface{}, min int, max int) ([]float64, error) {
+	if len(args) < min || (max != -1 && len(args) > max) {
+		expected := fmt.Sprint(min)
+		if max == -1 {
+			expected = fmt.Sprint("at least ", min)
+		} else if max != min {
+			expected = fmt.Sprint(min, " to ", max)
+		}
+		return nil, fmt.Errorf("%s() takes %s arguments, got %d", name, expected, len(args))
+	}
+	values := make([]float64, len(args))
+	for i, arg := range args {
+		value, ok := arg.(float64)
+		if !ok {
+			return nil, fmt.Errorf("%s() takes numbers, got %v", name, arg)
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// compileExpression compiles the expression with our functions, pointing
+// out the functions that are available when an unknown one is used
+func compileExpression(expression string, random func() float64) (*govaluate.EvaluableExpression, error) {
+	compiled, err := govaluate.NewEvaluableExpressionWithFunctions(expression, expressionFunctions(random))
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "Undefined function") {
+			err = fmt.Errorf("%v. Available functions are %s", err, strings.Join(FunctionNames(), ", "))
+		}
+		return nil, &FormulaError{Expression: expression, Compile: true, Err: err}
+	}
+	return compiled, nil
+}
@@ -0,0 +1,250 @@
+/*
+* Copyright 2020-present, Synopsys, Inc. * All rights reserved.
+*
+* This source code is licensed under the Apache-2.0 license found in
+* the LICENSE file in the root directory of this source tree. */
+package backoff
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// JSONMatcher matches output that is JSON, or JSON lines, with a value at
+// Path that compares to Value, such as
+//
+//	error.code == 429
+//	.status in ["RESOURCE_EXHAUSTED","UNAVAILABLE"]
+//	items[0].metadata.name
+//
+// The operators are ==, !=, <, <=, >, >=, in and not in. A path without
+// one matches when there is a value there at all.
+type JSONMatcher struct {
+	Scope Scope
+	// Object keys and array indexes, from the outside in
+	Path     []string
+	Operator string
+	Value    interface{}
+	// The condition as it was written
+	text string
+}
+
+// String gives the matcher as it is written on the command line
+func (m JSONMatcher) String() string {
+	return scoped(m.Scope, m.text)
+}
+
+var jsonCondition = regexp.MustCompile(`^([^\s=!<>]+)\s*(?:(==|!=|<=|>=|<|>)|\s(not in|in)\s)\s*(.*)$`)
+
+// ParseJSONMatchers converts a comma delimited list of JSON conditions,
+// each of which may start with the stream it is scoped to, as
+// ParseStringMatchers does. Commas inside brackets or quotes do not split
+// the list, so `.status in ["A","B"]` is a single condition. A condition
+// can also be quoted as the other lists are, such as "error.code == 429".
+func ParseJSONMatchers(s string) ([]JSONMatcher, error) {
+	log.Debug("Converting to JSON matcher array:", s)
+	var matchers []JSONMatcher
+	for _, field := range splitConditions(s) {
+		log.Debug(field)
+		field = strings.TrimSpace(field)
+		if len(field) >= 2 && strings.HasPrefix(field, `"`) && strings.HasSuffix(field, `"`) {
+			field = strings.ReplaceAll(field[1:len(field)-1], `""`, `"`)
+		}
+		scope, text := parseScope(field)
+		m, err := parseJSONMatcher(text)
+		if err != nil {
+			return nil, err
+		}
+		m.Scope = scope
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
+
+func parseJSONMatcher(text string) (JSONMatcher, error) {
+	m := JSONMatcher{text: text}
+	invalid := &ValueError{Setting: "json_matches", Value: text, Expected: `a path and a comparison, such as "error.code == 429"`}
+	path := text
+	if parts := jsonCondition.FindStringSubmatch(text); parts != nil {
+		path, m.Operator = parts[1], parts[2]+parts[3]
+		value := strings.TrimSpace(parts[4])
+		if value == "" {
+			return m, invalid
+		}
+		if err := json.Unmarshal([]byte(value), &m.Value); err != nil {
+			// Leave the quotes off strings if you like
+			m.Value = value
+		}
+		if _, ok := m.Value.([]interface{}); (m.Operator == "in" || m.Operator == "not in") && !ok {
+			return m, &ValueError{Setting: "json_matches", Value: text, Expected: `a list after "in", such as ["A","B"]`}
+		}
+	} else if strings.ContainsAny(path, " \t=!<>") {
+		return m, invalid
+	}
+	m.Path = splitPath(path)
+	if len(m.Path) == 0 {
+		return m, invalid
+	}
+	return m, nil
+}
+
+// splitPath splits a path such as ".items[0].name" into its keys and
+// indexes
+func splitPath(path string) []string {
+	path = strings.NewReplacer("[", ".", "]", "").Replace(path)
+	var keys []string
+	for _, key := range strings.Split(path, ".") {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// splitConditions splits a comma delimited list, other than at commas
+// inside brackets or quotes
+func splitConditions(s string) []string {
+	var fields []string
+	depth, quoted, start := 0, false, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			fields = append(fields, s[start:i])
+			start = i + 1
+		}
+	}
+	if strings.TrimSpace(s[start:]) != "" {
+		fields = append(fields, s[start:])
+	}
+	return fields
+}
+
+// parseDocuments reads text as a stream of JSON values, which covers a
+// single document and JSON lines. When that fails, such as when the JSON
+// is mixed in with other output, every line that is JSON on its own is used.
+func parseDocuments(text string) []interface{} {
+	var documents []interface{}
+	decoder := json.NewDecoder(strings.NewReader(text))
+	for {
+		var document interface{}
+		err := decoder.Decode(&document)
+		if err == io.EOF {
+			return documents
+		}
+		if err != nil {
+			break
+		}
+		documents = append(documents, document)
+	}
+
+	documents = nil
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "{") && !strings.HasPrefix(line, "[") {
+			continue
+		}
+		var document interface{}
+		if json.Unmarshal([]byte(line), &document) == nil {
+			documents = append(documents, document)
+		}
+	}
+	return documents
+}
+
+// matches reports whether any of the documents has a value at the path
+// that compares as asked
+func (m JSONMatcher) matches(documents []interface{}) bool {
+	for _, document := range documents {
+		value, ok := lookup(document, m.Path)
+		if ok && compare(value, m.Operator, m.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+func lookup(value interface{}, path []string) (interface{}, bool) {
+	for _, key := range path {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if value, ok = v[key]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			value = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func compare(value interface{}, operator string, expected interface{}) bool {
+	switch operator {
+	case "":
+		return true
+	case "==":
+		return reflect.DeepEqual(value, expected)
+	case "!=":
+		return !reflect.DeepEqual(value, expected)
+	case "in", "not in":
+		found := false
+		for _, e := range expected.([]interface{}) {
+			found = found || reflect.DeepEqual(value, e)
+		}
+		return found == (operator == "in")
+	}
+
+	// Only numbers, and strings, can be ordered
+	var order int
+	switch v := value.(type) {
+	case float64:
+		e, ok := expected.(float64)
+		if !ok {
+			return false
+		}
+		switch {
+		case v < e:
+			order = -1
+		case v > e:
+			order = 1
+		}
+	case string:
+		e, ok := expected.(string)
+		if !ok {
+			return false
+		}
+		order = strings.Compare(v, e)
+	default:
+		return false
+	}
+	switch operator {
+	case "<":
+		return order < 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	case ">=":
+		return order >= 0
+	}
+	return false
+}
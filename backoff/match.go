@@ -0,0 +1,84 @@
+/*
+* Copyright 2020-present, Synopsys, Inc. * All rights reserved.
+*
+* This source code is licensed under the Apache-2.0 license found in
+* the LICENSE file in the root directory of this source tree. */
+package backoff
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Scope is which of an attempt's output a matcher looks at
+type Scope int
+
+const (
+	// AnyStream matches stdout or stderr, and the error returned to Do
+	AnyStream Scope = iota
+	// StdoutStream only matches stdout
+	StdoutStream
+	// StderrStream only matches stderr
+	StderrStream
+)
+
+func (s Scope) String() string {
+	switch s {
+	case StdoutStream:
+		return "stdout"
+	case StderrStream:
+		return "stderr"
+	}
+	return "any"
+}
+
+// includes reports whether the scope covers the stream of that name
+func (s Scope) includes(stream string) bool {
+	return s == AnyStream || s.String() == stream
+}
+
+// parseScope splits a scope such as "stderr:" off the front of a matcher.
+// Matchers without one are AnyStream, so "any:" is only needed to match
+// text that starts with a scope.
+func parseScope(field string) (Scope, string) {
+	for _, scope := range []Scope{AnyStream, StdoutStream, StderrStream} {
+		if text, ok := strings.CutPrefix(field, scope.String()+":"); ok {
+			return scope, text
+		}
+	}
+	return AnyStream, field
+}
+
+// scoped writes a matcher the way parseScope reads it
+func scoped(scope Scope, text string) string {
+	if scope == AnyStream {
+		if s, _ := parseScope(text); s != AnyStream {
+			return "any:" + text
+		}
+		return text
+	}
+	return scope.String() + ":" + text
+}
+
+// StringMatcher matches output that contains Text
+type StringMatcher struct {
+	Scope Scope
+	Text  string
+}
+
+// String gives the matcher as it is written on the command line
+func (m StringMatcher) String() string {
+	return scoped(m.Scope, m.Text)
+}
+
+// RegexpMatcher matches output that Regexp matches
+type RegexpMatcher struct {
+	Scope Scope
+	*regexp.Regexp
+}
+
+// String gives the matcher as it is written on the command line, rather
+// than the bare expression
+func (m RegexpMatcher) String() string {
+	return scoped(m.Scope, m.Regexp.String())
+}
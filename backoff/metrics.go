@@ -0,0 +1,53 @@
+/*
+* Copyright 2020-present, Synopsys, Inc. * All rights reserved.
+*
+* This source code is licensed under the Apache-2.0 license found in
+* the LICENSE file in the root directory of this source tree. */
+package backoff
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+)
+
+// MetricsFile is the csv file metrics are appended to when enabled
+const MetricsFile = "eb-metrics.csv"
+
+func logMetric(startTime string, endTime string, elapsedTime string, command string, args string, result string, output string, stdErr string) error {
+	headers := []string{"startTime", "endTime", "elapsedTime", "command", "args", "result", "output", "stdErr"}
+	data := []string{startTime, endTime, elapsedTime, command, args, result, output, stdErr}
+
+	info, err := os.Stat(MetricsFile)
+
+	//create new file if it doesn't exist. Insert headers
+	if os.IsNotExist(err) {
+		log.Debug("Creating ", MetricsFile)
+		csvFile, err := os.Create(MetricsFile)
+		if err != nil {
+			return fmt.Errorf("unable to create %s: %w", MetricsFile, err)
+		}
+
+		w := csv.NewWriter(csvFile)
+		w.Write(headers)
+		w.Flush()
+		csvFile.Close()
+		log.Debug("Created ", MetricsFile)
+
+	} else if err != nil {
+		return err
+	} else if info.IsDir() {
+		// Error if the file we are supposed to write to is a directory
+		return fmt.Errorf("%s is a directory", MetricsFile)
+	}
+
+	log.Debug("Logging metrics ", data)
+	csvFile, err := os.OpenFile(MetricsFile, os.O_APPEND|os.O_RDWR, 0666)
+	if err != nil {
+		return fmt.Errorf("unable to write to %s: %w", MetricsFile, err)
+	}
+	w := csv.NewWriter(csvFile) // Create a new to the file stream
+	w.Write(data)
+	w.Flush()
+	return csvFile.Close()
+}
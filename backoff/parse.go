@@ -0,0 +1,137 @@
+/*
+* Copyright 2020-present, Synopsys, Inc. * All rights reserved.
+*
+* This source code is licensed under the Apache-2.0 license found in
+* the LICENSE file in the root directory of this source tree. */
+package backoff
+
+import (
+	"encoding/csv"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	shellwords "github.com/mattn/go-shellwords"
+)
+
+// Lists are treated as a row from a CSV file so we don't need to do
+// intelligent parsing. The values "1,2,3" and "1","2","3" are synonymous.
+func readList(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	r := csv.NewReader(strings.NewReader(s))
+	fields, err := r.Read()
+	if err != nil {
+		return nil, &ListError{List: s, Err: err}
+	}
+	return fields, nil
+}
+
+// ParseExitCodes converts a comma delimited list of exit codes
+func ParseExitCodes(s string) ([]int, error) {
+	log.Debug("Converting to int array:", s)
+	fields, err := readList(s)
+	if err != nil {
+		return nil, &ExitCodeError{List: s, Err: err}
+	}
+	var codes []int
+	for _, field := range fields {
+		code, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, &ExitCodeError{List: s, Err: err}
+		}
+		log.Debug(code)
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// ParseStrings converts a comma delimited list of strings
+func ParseStrings(s string) ([]string, error) {
+	log.Debug("Converting to string array:", s)
+	fields, err := readList(s)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range fields {
+		log.Debug(field)
+	}
+	return fields, nil
+}
+
+// ParseRegexps converts a comma delimited list of regular expressions
+func ParseRegexps(s string) ([]*regexp.Regexp, error) {
+	log.Debug("Converting to regexp array:", s)
+	fields, err := readList(s)
+	if err != nil {
+		return nil, err
+	}
+	var regexps []*regexp.Regexp
+	for _, field := range fields {
+		log.Debug(field)
+		re, err := regexp.Compile(field)
+		if err != nil {
+			return nil, &RegexpError{Regexp: field, Err: err}
+		}
+		regexps = append(regexps, re)
+	}
+	return regexps, nil
+}
+
+// ParseStringMatchers converts a comma delimited list of strings, each of
+// which may start with the stream it is scoped to: "stdout:", "stderr:" or
+// "any:"
+func ParseStringMatchers(s string) ([]StringMatcher, error) {
+	fields, err := ParseStrings(s)
+	if err != nil {
+		return nil, err
+	}
+	var matchers []StringMatcher
+	for _, field := range fields {
+		scope, text := parseScope(field)
+		matchers = append(matchers, StringMatcher{Scope: scope, Text: text})
+	}
+	return matchers, nil
+}
+
+// ParseRegexpMatchers converts a comma delimited list of regular
+// expressions, each of which may start with the stream it is scoped to, as
+// ParseStringMatchers does
+func ParseRegexpMatchers(s string) ([]RegexpMatcher, error) {
+	log.Debug("Converting to regexp array:", s)
+	fields, err := readList(s)
+	if err != nil {
+		return nil, err
+	}
+	var matchers []RegexpMatcher
+	for _, field := range fields {
+		log.Debug(field)
+		scope, expression := parseScope(field)
+		re, err := regexp.Compile(expression)
+		if err != nil {
+			return nil, &RegexpError{Regexp: expression, Err: err}
+		}
+		matchers = append(matchers, RegexpMatcher{Scope: scope, Regexp: re})
+	}
+	return matchers, nil
+}
+
+// ParseCommand splits a command string into its arguments the way a shell
+// would
+func ParseCommand(s string) ([]string, error) {
+	log.Debug("Parsing:", s)
+	command, err := shellwords.Parse(s)
+	if err != nil {
+		return nil, &CommandError{Command: s, Err: err}
+	}
+	if len(command) == 0 {
+		return nil, &CommandError{Command: s, Err: errors.New("no command given")}
+	}
+	log.Debug("Command string parsed as:\n")
+	for _, field := range command {
+		log.Debug(field)
+	}
+	return command, nil
+}
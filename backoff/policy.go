@@ -0,0 +1,178 @@
+/*
+* Copyright 2020-present, Synopsys, Inc. * All rights reserved.
+*
+* This source code is licensed under the Apache-2.0 license found in
+* the LICENSE file in the root directory of this source tree. */
+package backoff
+
+import (
+	"io"
+	"math/rand"
+	"regexp"
+	"syscall"
+	"time"
+)
+
+// Policy holds everything needed to decide whether, and how long after,
+// a command should be retried. The zero value never retries; use
+// NewPolicy to get the same defaults as the eb command line.
+type Policy struct {
+	// Expression is a govaluate expression giving the number of seconds
+	// to wait before the next retry. It may use 'x' (0 based attempt),
+	// 'i' (1 based attempt), 'r' (a random float from 0-1), 'elapsed'
+	// (seconds since the first attempt started), 'last_duration' (seconds
+	// the last attempt ran for), 'exit_code' (of the last attempt),
+	// 'remaining' (seconds left in Duration, -1 for unlimited), 'retries'
+	// (Retries), 'prev_sleep' (seconds waited before the last attempt) and
+	// 'hint' (seconds asked for by RetryAfterRegexps, -1 for no hint).
+	// Empty uses Strategy instead.
+	Expression string
+	// Strategy is used to compute the wait when Expression is empty. With
+	// neither set there is no wait between attempts.
+	Strategy Strategy
+	// Retries is the maximum number of retries. -1 retries forever.
+	Retries int
+	// Duration is how long to keep retrying for. A negative value
+	// retries forever.
+	Duration time.Duration
+
+	// Stop an attempt that is still running when Duration runs out, rather
+	// than letting it finish
+	DurationStopsAttempt bool
+
+	// AttemptTimeout stops a single attempt that runs for longer than this.
+	// Zero lets an attempt run for as long as it likes.
+	AttemptTimeout time.Duration
+	// Retry attempts stopped by AttemptTimeout. RetryOnAll retries them too.
+	RetryOnTimeout bool
+	// KillGracePeriod is how long a running command is given to exit after
+	// being sent SIGTERM, when it times out or the context passed to Run is
+	// cancelled, before it is killed. Zero kills it straight away.
+	KillGracePeriod time.Duration
+
+	// Rules are tried in order before the matchers below, and the first to
+	// match an attempt decides whether it is retried, and how long to wait.
+	// The matchers below are tried as rules too, in the order AllRules
+	// gives. An attempt no rule matches succeeds if it exited with 0 and
+	// fails otherwise.
+	Rules []Rule
+	// Explain writes the rules to Stderr before the first attempt, and
+	// which rule decided each attempt
+	Explain bool
+
+	// Only match against the end of stdout and stderr, including when
+	// looking for RetryAfterRegexps. The zero value matches all of it.
+	MatchWindow MatchWindow
+
+	// The string, regexp and JSON matchers below look at both stdout and stderr,
+	// unless their Scope narrows them to one of them
+
+	// Retry on any non-zero exit code
+	RetryOnAll bool
+	// Retry when the command exits with one of these codes
+	RetryOnExitCodes []int
+	// Retry when the command is killed by one of these signals
+	RetryOnSignals []syscall.Signal
+	// Retry when stdout or stderr contains one of these strings
+	RetryOnStrings []StringMatcher
+	// Retry when stdout or stderr matches one of these regexps
+	RetryOnRegexps []RegexpMatcher
+	// Retry when stdout or stderr is JSON that one of these conditions holds for
+	RetryOnJSON []JSONMatcher
+	// Retry a failed attempt when this govaluate expression is true, such as
+	// "exit_code == 1 && duration < 2". See ConditionVariables and
+	// ConditionFunctionNames for what it can use.
+	RetryIf string
+
+	// Treat these exit codes as success
+	SuccessOnExitCodes []int
+	// Treat the command as successful when stdout or stderr contains one of these strings
+	SuccessOnStrings []StringMatcher
+	// Treat the command as successful when stdout or stderr matches one of these regexps
+	SuccessOnRegexps []RegexpMatcher
+	// Treat the command as successful when stdout or stderr is JSON that one of these conditions holds for
+	SuccessOnJSON []JSONMatcher
+	// Treat the command as successful when this expression is true
+	SucceedIf string
+
+	// Read how long to wait before retrying from the output of the command,
+	// such as "Retry-After: 30". The first capture group of the first of
+	// these to match is read as seconds, a duration such as "1m30s", or an
+	// HTTP date. The wait is at least that long, still clamped to Duration.
+	RetryAfterRegexps []*regexp.Regexp
+	// Wait for as long as the hint from RetryAfterRegexps asks, rather than
+	// for at least that long
+	RetryAfterOverrides bool
+
+	// Retry when Do's function returns an error one of these match
+	RetryOnErrors []ErrorMatcher
+	// Treat an error returned by Do's function as success when one of these match
+	SuccessOnErrors []ErrorMatcher
+
+	// Treat the command as failed when stdout or stderr contains one of these strings
+	FailOnStrings []StringMatcher
+	// Treat the command as failed when stdout or stderr matches one of these regexps
+	FailOnRegexps []RegexpMatcher
+	// Treat the command as failed when stdout or stderr is JSON that one of these conditions holds for
+	FailOnJSON []JSONMatcher
+	// Fail the command without retrying when this expression is true
+	FailIf string
+	// Treat the command as failed unless stdout or stderr contains one of these strings
+	FailUnlessStrings []StringMatcher
+	// Treat the command as failed unless stdout or stderr matches one of these regexps
+	FailUnlessRegexps []RegexpMatcher
+
+	// A command to run before every retry. Useful for cleanup.
+	PerformOnFailure string
+	// A command to run once the retrying is over, whether the command
+	// succeeded or not. Useful for uploading metrics.
+	PerformOnExit string
+
+	// Whether the output of the command is shown as it is produced, or
+	// only once the attempt has been classified
+	OutputMode OutputMode
+	// Prefix every line of streamed output with the attempt number
+	PrefixOutput bool
+	// Where the output of the command and retry messages are written.
+	// Default to os.Stdout and os.Stderr.
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Stdin is read as the attempts ask for it, and every attempt is given
+	// the same input from the start. It is never read ahead of an attempt,
+	// so a pipe that is never closed does not hold up the command. Nil gives
+	// the command no input.
+	Stdin io.Reader
+	// How much of Stdin is held in memory before the rest is spooled to a
+	// temporary file. Zero uses DefaultStdinMemoryLimit.
+	StdinMemoryLimit int64
+	// Give the command Stdin as is, rather than replaying it, so it can be
+	// used interactively. Stdin can only be read once, so this is ignored
+	// unless Retries is 0.
+	PassthroughStdin bool
+
+	// Print a simple retrying message prior to retrying
+	PrintRetryOnFailure bool
+	// Print the output of the failed attempt as well as the retrying message
+	PrintVerboseRetryOnFailure bool
+	// Append a row per attempt to eb-metrics.csv
+	MetricsEnabled bool
+
+	// Clock tells the time and waits between attempts. Nil uses the real
+	// clock.
+	Clock Clock
+	// Random is where the expression's randomness comes from. Nil seeds a
+	// new source from the clock on every run.
+	Random rand.Source
+}
+
+// NewPolicy returns a Policy with the same defaults as the eb command line:
+// no wait between attempts, and no limit on retries or duration.
+func NewPolicy() Policy {
+	return Policy{
+		Retries:  -1,
+		Duration: -1,
+
+		KillGracePeriod: 10 * time.Second,
+	}
+}
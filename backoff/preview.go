@@ -0,0 +1,91 @@
+/*
+* Copyright 2020-present, Synopsys, Inc. * All rights reserved.
+*
+* This source code is licensed under the Apache-2.0 license found in
+* the LICENSE file in the root directory of this source tree. */
+package backoff
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// PreviewStep is one wait between attempts worked out by Preview
+type PreviewStep struct {
+	// The attempt the wait comes before. The first retry is attempt 2.
+	Attempt int
+	// How long is waited before the attempt
+	Sleep time.Duration
+	// What the expression asked for, before it was clamped to Duration
+	Unclamped time.Duration
+	// The total time waited once this wait is over
+	Elapsed time.Duration
+}
+
+// Clamped reports whether the wait was shortened so as not to run past
+// Policy.Duration
+func (s PreviewStep) Clamped() bool {
+	return s.Sleep != s.Unclamped
+}
+
+// Schedule is the waits a Policy makes between attempts, as worked out by
+// Preview
+type Schedule struct {
+	Steps []PreviewStep
+	// Why retrying stops after the last step
+	Reason Reason
+	// Set when the schedule was cut short at the limit given to Preview
+	// before retrying would have stopped
+	Truncated bool
+}
+
+// Total is the time spent waiting over the whole schedule
+func (s Schedule) Total() time.Duration {
+	var total time.Duration
+	for _, step := range s.Steps {
+		total += step.Sleep
+	}
+	return total
+}
+
+// Preview works out the waits policy makes between attempts without running
+// anything, as if every attempt failed with exit code 1 straight away. The
+// expression's randomness is seeded with seed, so the same seed gives the
+// same schedule. At most limit retries are worked out.
+func Preview(policy Policy, seed int64, limit int) (Schedule, error) {
+	formula, err := compileFormula(policy, rand.New(rand.NewSource(seed)))
+	if err != nil {
+		return Schedule{}, err
+	}
+
+	var schedule Schedule
+	var elapsed, prevSleep time.Duration
+	for xIncrement := 1; ; xIncrement++ {
+		if reason, done := exhausted(policy, xIncrement, elapsed); done {
+			schedule.Reason = reason
+			return schedule, nil
+		}
+		if xIncrement > limit {
+			schedule.Truncated = true
+			return schedule, nil
+		}
+		state := loopState{xIncrement: xIncrement, elapsed: elapsed, exitCode: 1, prevSleep: prevSleep}
+		unclamped, err := formula.wait(policy, state)
+		if err != nil {
+			return schedule, err
+		}
+		sleepFor := clampToDuration(policy, elapsed, unclamped)
+		if elapsed += sleepFor; elapsed < 0 {
+			// The expression grew past what a time.Duration can hold
+			elapsed = math.MaxInt64
+		}
+		prevSleep = sleepFor
+		schedule.Steps = append(schedule.Steps, PreviewStep{
+			Attempt:   xIncrement + 1,
+			Sleep:     sleepFor,
+			Unclamped: unclamped,
+			Elapsed:   elapsed,
+		})
+	}
+}
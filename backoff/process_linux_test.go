@@ -0,0 +1,162 @@
+/*
+* Copyright 2020-present, Synopsys, Inc. * All rights reserved.
+*
+* This source code is licensed under the Apache-2.0 license found in
+* the LICENSE file in the root directory of this source tree. */
+
+package backoff
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// openPty opens a new pseudo terminal, returning its master and the path
+// of its slave
+func openPty(t *testing.T) (*os.File, string) {
+	t.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skip("no pseudo terminals: ", err)
+	}
+	t.Cleanup(func() { master.Close() })
+	var unlock, n int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		t.Skip("unable to unlock the pseudo terminal: ", errno)
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); errno != 0 {
+		t.Skip("unable to name the pseudo terminal: ", errno)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+// terminalOutput is what has been written to a pseudo terminal
+type terminalOutput struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (o *terminalOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.Write(p)
+}
+
+func (o *terminalOutput) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.String()
+}
+
+// onTerminal reports whether the test is being run by runOnTerminal
+func onTerminal() bool {
+	return os.Getenv("EB_TEST_TERMINAL") != ""
+}
+
+// runOnTerminal runs the test named name again as the session leader of a
+// new pseudo terminal, so it is the terminal's foreground process group, as
+// eb is when run from a shell. typing is called to type at the terminal,
+// and is given what has been written to it.
+func runOnTerminal(t *testing.T, name string, typing func(master *os.File, output *terminalOutput)) {
+	t.Helper()
+	master, slavePath := openPty(t)
+	slave, err := os.OpenFile(slavePath, os.O_RDWR, 0)
+	if err != nil {
+		t.Skip("unable to open the pseudo terminal: ", err)
+	}
+	defer slave.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, os.Args[0], "-test.run=^"+name+"$", "-test.v")
+	cmd.Env = append(os.Environ(), "EB_TEST_TERMINAL=1")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	slave.Close()
+
+	output := &terminalOutput{}
+	copied := make(chan struct{})
+	go func() {
+		// Ends with EIO once the last of the slave is closed
+		buf := make([]byte, 4096)
+		for {
+			n, err := master.Read(buf)
+			output.Write(buf[:n])
+			if err != nil {
+				break
+			}
+		}
+		close(copied)
+	}()
+	if typing != nil {
+		typing(master, output)
+	}
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		t.Fatalf("expected %s to finish on the terminal, but it hung:\n%s", name, output)
+	}
+	master.Close()
+	<-copied
+	if err != nil || !strings.Contains(output.String(), "--- PASS: "+name) {
+		t.Errorf("expected %s to pass on the terminal, got %v:\n%s", name, err, output)
+	}
+}
+
+func TestRunTerminalPrompt(t *testing.T) {
+	if !onTerminal() {
+		runOnTerminal(t, "TestRunTerminalPrompt", func(master *os.File, output *terminalOutput) {
+			master.Write([]byte("yes\n"))
+		})
+		return
+	}
+
+	// Answer a prompt read from the terminal, as a password prompt would be
+	policy := NewPolicy()
+	policy.Retries = 0
+	result := Run(context.Background(), policy, []string{"sh", "-c", `read answer < /dev/tty && echo "answered $answer"`})
+	if result.ExitCode != 0 || !strings.Contains(result.Stdout, "answered yes") {
+		t.Errorf("expected the command to read from the terminal, got %d: %q", result.ExitCode, result.Stdout)
+	}
+}
+
+func TestRunTerminalStopsProcessGroup(t *testing.T) {
+	// The command has its own process group on a terminal too
+	runOnTerminal(t, "TestRunStopsProcessGroup", nil)
+}
+
+func TestRunTerminalInterrupt(t *testing.T) {
+	if !onTerminal() {
+		runOnTerminal(t, "TestRunTerminalInterrupt", func(master *os.File, output *terminalOutput) {
+			for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(10 * time.Millisecond) {
+				if strings.Contains(output.String(), "ready") {
+					break
+				}
+			}
+			// Ctrl-C
+			master.Write([]byte{3})
+		})
+		return
+	}
+
+	// Ctrl-C only reaches the command, which stops the retrying as eb
+	// receiving SIGINT would
+	policy := NewPolicy()
+	policy.Retries = 5
+	policy.RetryOnAll = true
+	result := Run(context.Background(), policy, []string{"sh", "-c", "echo ready > /dev/tty; sleep 30"})
+	if result.Reason != Cancelled || result.ExitCode != 130 || len(result.Attempts) != 1 {
+		t.Errorf("expected Ctrl-C to stop the run with 130, got %d (%s) after %d attempts", result.ExitCode, result.Reason, len(result.Attempts))
+	}
+}
@@ -0,0 +1,90 @@
+//go:build !windows
+
+/*
+* Copyright 2020-present, Synopsys, Inc. * All rights reserved.
+*
+* This source code is licensed under the Apache-2.0 license found in
+* the LICENSE file in the root directory of this source tree. */
+
+package backoff
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"unsafe"
+)
+
+// startInProcessGroup makes the command the leader of its own process
+// group, so anything it starts can be signalled along with it. When eb is
+// in the foreground of a terminal, the group is given the foreground, as a
+// background group is stopped by SIGTTIN when it reads from the terminal,
+// such as for a password prompt. foreground is whether it was, and restore
+// gives the foreground back to eb once the command has exited.
+func startInProcessGroup(cmd *exec.Cmd) (restore func(), foreground bool) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		// No controlling terminal
+		return func() {}, false
+	}
+	group, err := foregroundGroup(tty)
+	if err != nil || group != syscall.Getpgrp() {
+		tty.Close()
+		return func() {}, false
+	}
+	log.Debug("Giving the command the terminal's foreground")
+	cmd.SysProcAttr.Foreground = true
+	cmd.SysProcAttr.Ctty = int(tty.Fd())
+	return func() {
+		if err := setForegroundGroup(tty, group); err != nil {
+			log.Warning("Unable to take back the terminal's foreground: ", err)
+		}
+		tty.Close()
+	}, true
+}
+
+// foregroundGroup is the foreground process group of the terminal
+func foregroundGroup(tty *os.File) (int, error) {
+	var group int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, tty.Fd(), syscall.TIOCGPGRP, uintptr(unsafe.Pointer(&group))); errno != 0 {
+		return 0, errno
+	}
+	return int(group), nil
+}
+
+// setForegroundGroup gives the terminal's foreground to group. eb is in the
+// background while it does, which would stop it with SIGTTOU unless that
+// is ignored.
+func setForegroundGroup(tty *os.File, group int) error {
+	if !signal.Ignored(syscall.SIGTTOU) {
+		signal.Ignore(syscall.SIGTTOU)
+		defer signal.Reset(syscall.SIGTTOU)
+	}
+	g := int32(group)
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, tty.Fd(), syscall.TIOCSPGRP, uintptr(unsafe.Pointer(&g))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// terminate sends sig to the command and everything it started
+func terminate(cmd *exec.Cmd, sig syscall.Signal) error {
+	return signalProcessGroup(cmd, sig)
+}
+
+// killProcessGroup kills anything the command started that is still
+// running after the command itself has exited
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+	signalProcessGroup(cmd, syscall.SIGKILL)
+}
+
+func signalProcessGroup(cmd *exec.Cmd, sig syscall.Signal) error {
+	log.Debug("Sending ", sig, " to process group ", cmd.Process.Pid)
+	// A negative pid signals every process in the group
+	return syscall.Kill(-cmd.Process.Pid, sig)
+}
@@ -0,0 +1,32 @@
+//go:build windows
+
+/*
+* Copyright 2020-present, Synopsys, Inc. * All rights reserved.
+*
+* This source code is licensed under the Apache-2.0 license found in
+* the LICENSE file in the root directory of this source tree. */
+
+package backoff
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// startInProcessGroup starts the command in a new process group, so it does
+// not receive the console's Ctrl-C meant for eb. The console is shared, so
+// there is no foreground to hand over.
+func startInProcessGroup(cmd *exec.Cmd) (restore func(), foreground bool) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+	return func() {}, false
+}
+
+// terminate kills the command. Windows cannot deliver unix signals, so
+// sig is ignored.
+func terminate(cmd *exec.Cmd, sig syscall.Signal) error {
+	return cmd.Process.Kill()
+}
+
+// killProcessGroup is a no-op. Windows does not let us signal a process
+// group the way unix does.
+func killProcessGroup(cmd *exec.Cmd) {}
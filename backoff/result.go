@@ -0,0 +1,133 @@
+/*
+* Copyright 2020-present, Synopsys, Inc. * All rights reserved.
+*
+* This source code is licensed under the Apache-2.0 license found in
+* the LICENSE file in the root directory of this source tree. */
+package backoff
+
+import (
+	"syscall"
+	"time"
+)
+
+// Reason describes why the retry loop stopped
+type Reason int
+
+const (
+	// Succeeded means the final attempt was classified as a success
+	Succeeded Reason = iota
+	// Failed means the final attempt failed in a way that is not retried
+	Failed
+	// FailOnMatch means a rule with the Fail or FailContinue action, such as
+	// fail_on or fail_unless, failed the command. The exit code is that of
+	// the command for a Fail rule, and ForcedFailureExitCode otherwise.
+	FailOnMatch
+	// RetriesExhausted means the command kept failing until Policy.Retries ran out
+	RetriesExhausted
+	// DurationExhausted means the command kept failing until Policy.Duration ran out
+	DurationExhausted
+	// ExpressionError means Policy.Expression could not be compiled or
+	// evaluated. When it cannot be, no attempt is made.
+	ExpressionError
+	// Cancelled means the context passed to Run was cancelled or passed its
+	// deadline before the command succeeded
+	Cancelled
+	// HookFailed means Policy.PerformOnFailure could not be run, or exited
+	// with a non-zero exit code, so retrying was abandoned
+	HookFailed
+	// TimedOut means the final attempt ran for longer than
+	// Policy.AttemptTimeout, and timeouts are not retried
+	TimedOut
+)
+
+// TimeoutExitCode is the exit code given to an attempt that ran for longer
+// than Policy.AttemptTimeout. It is the same exit code timeout(1) uses.
+const TimeoutExitCode = 124
+
+// ForcedFailureExitCode is the exit code given when a FailContinue rule or
+// one of the fail settings, such as fail_on_string_matches, fails an
+// attempt, or a Fail rule fails one that exited with 0. Result.Reason is
+// FailOnMatch, which tells it apart from a command that exited with 255
+// itself.
+const ForcedFailureExitCode = 255
+
+// InternalErrorExitCode is the exit code given when eb itself fails, such
+// as when Policy.Expression cannot be compiled or evaluated, rather than the
+// command. It is the same exit code timeout(1) uses for its own failures.
+const InternalErrorExitCode = 125
+
+func (r Reason) String() string {
+	switch r {
+	case Succeeded:
+		return "succeeded"
+	case Failed:
+		return "failed"
+	case FailOnMatch:
+		return "fail on match"
+	case RetriesExhausted:
+		return "retries exhausted"
+	case DurationExhausted:
+		return "duration exhausted"
+	case ExpressionError:
+		return "expression error"
+	case Cancelled:
+		return "cancelled"
+	case HookFailed:
+		return "hook failed"
+	case TimedOut:
+		return "timed out"
+	}
+	return "unknown"
+}
+
+// Attempt records a single run of the command
+type Attempt struct {
+	// 1 based attempt number
+	Number int
+	Start  time.Time
+	// How long the command ran for
+	Elapsed time.Duration
+	// The exit code the command returned, before any matchers were applied.
+	// 128 plus the signal number when it was killed by a signal.
+	ExitCode int
+	// The signal that killed the command, or 0 when it exited
+	Signal syscall.Signal
+	// How long the loop waited after this attempt before retrying. Zero for
+	// the final attempt.
+	Sleep time.Duration
+	// Whether the attempt was stopped by Policy.AttemptTimeout
+	TimedOut bool
+}
+
+// Result describes the outcome of Run
+type Result struct {
+	// The exit code eb should exit with, after matchers were applied
+	ExitCode int
+	Reason   Reason
+	// Every attempt that was made, in order. len(Attempts) is the attempt count.
+	Attempts []Attempt
+	// Output of the final attempt
+	Stdout string
+	Stderr string
+	// The setting that decided the final classification, such as
+	// `retry_on_string_matches "Quota exceeded"`. Empty when the exit code
+	// was used as is.
+	Rule string
+	// Identifies this run. Given to every attempt as EB_RUN_ID.
+	RunID string
+	// Set when the expression could not be evaluated, the final attempt
+	// could not be started, a perform on failure or perform on exit command
+	// failed, or the run was cancelled with a signal. A *HookError for the
+	// hooks, and a *SignalError for a signal.
+	Err error
+}
+
+// Elapsed is the time from the start of the first attempt to the end of
+// the last one
+func (r Result) Elapsed() time.Duration {
+	if len(r.Attempts) == 0 {
+		return 0
+	}
+	last := r.Attempts[len(r.Attempts)-1]
+	return last.Start.Add(last.Elapsed).Sub(r.Attempts[0].Start)
+}
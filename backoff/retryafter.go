@@ -0,0 +1,60 @@
+/*
+* Copyright 2020-present, Synopsys, Inc. * All rights reserved.
+*
+* This source code is licensed under the Apache-2.0 license found in
+* the LICENSE file in the root directory of this source tree. */
+package backoff
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// retryAfter looks for a hint of how long to wait before retrying, such as
+// "Retry-After: 30", in the output of the last attempt. The first capture
+// group of the first of Policy.RetryAfterRegexps to match is used, or the
+// whole match when there is no group.
+func retryAfter(policy Policy, now time.Time, outputs ...string) (time.Duration, bool) {
+	for _, re := range policy.RetryAfterRegexps {
+		for _, output := range outputs {
+			match := re.FindStringSubmatch(output)
+			if match == nil {
+				continue
+			}
+			value := match[0]
+			if len(match) > 1 {
+				value = match[1]
+			}
+			hint, ok := parseRetryAfter(value, now)
+			if !ok {
+				log.Warning("Unable to read a retry after hint from", strconv.Quote(value))
+				continue
+			}
+			log.Info("Found a retry after hint of ", hint)
+			return hint, true
+		}
+	}
+	return 0, false
+}
+
+// parseRetryAfter reads a number of seconds, a duration such as "1m30s", or
+// an HTTP date, which gives the time until then
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 && seconds < math.MaxInt64/float64(time.Second) {
+		return time.Duration(seconds * float64(time.Second)), true
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return d, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if d := date.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
@@ -0,0 +1,453 @@
+/*
+* Copyright 2020-present, Synopsys, Inc. * All rights reserved.
+*
+* This source code is licensed under the Apache-2.0 license found in
+* the LICENSE file in the root directory of this source tree. */
+package backoff
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	shellwords "github.com/mattn/go-shellwords"
+)
+
+// Action is what a Rule does with an attempt it matches
+type Action int
+
+const (
+	// Retry the attempt
+	Retry Action = iota
+	// Succeed treats the attempt as successful, exiting with 0
+	Succeed
+	// Fail stops retrying, treating the attempt as failed
+	Fail
+	// Continue notes the match and carries on to the next rule. Useful with
+	// --explain.
+	Continue
+	// FailContinue fails the attempt with ForcedFailureExitCode and carries
+	// on to the next rule, skipping those that succeed, so a later rule can
+	// still retry the failure or stop retrying it. The fail_on and
+	// fail_unless settings are fail-continue rules.
+	FailContinue
+)
+
+func (a Action) String() string {
+	switch a {
+	case Retry:
+		return "retry"
+	case Succeed:
+		return "succeed"
+	case Fail:
+		return "fail"
+	case Continue:
+		return "continue"
+	case FailContinue:
+		return "fail-continue"
+	}
+	return "unknown"
+}
+
+// ParseAction reads the name of an Action. Empty is Retry.
+func ParseAction(s string) (Action, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "retry":
+		return Retry, nil
+	case "succeed", "success":
+		return Succeed, nil
+	case "fail":
+		return Fail, nil
+	case "continue":
+		return Continue, nil
+	case "fail-continue":
+		return FailContinue, nil
+	}
+	return Retry, &ValueError{Setting: "action", Value: s, Expected: "retry, succeed, fail, continue or fail-continue"}
+}
+
+// Rule pairs a condition with what to do with the attempts it matches.
+// Rules are tried in order, and the first to match with an action other
+// than Continue or FailContinue decides what happens to the attempt.
+type Rule struct {
+	// Names the rule in logs and Result.Rule
+	Name string
+
+	// The rule matches when the command exits with one of these codes, is
+	// killed by one of these signals, stdout or stderr contains one of these
+	// strings or matches one of these regexps, is JSON that one of these
+	// conditions holds for, or an error returned to Do matches one of these
+	// matchers. A rule with none of these matches every attempt.
+	ExitCodes []int
+	Signals   []syscall.Signal
+	Strings   []StringMatcher
+	Regexps   []RegexpMatcher
+	JSON      []JSONMatcher
+	Errors    []ErrorMatcher
+	// Match when none of the above do, instead of when one does
+	Unless bool
+	// Only match attempts that exited with a non-zero exit code. Errors
+	// returned to Do always count as failures. Retry rules only match
+	// failures unless OnSuccess is set.
+	OnlyOnFailure bool
+	// Let a Retry rule match an attempt that succeeded, to retry it anyway
+	OnSuccess bool
+	// A govaluate expression that must also be true for the rule to match,
+	// such as "exit_code == 1 && duration < 2". It is given as retry_if,
+	// succeed_if or fail_if, which also set the Action.
+	If string
+
+	Action Action
+	// The wait before a retry this rule asks for. 'x' and 'i' count the
+	// retries this rule has asked for, rather than all of them. Empty uses
+	// the policy's Expression or Strategy.
+	Expression string
+	// The most retries this rule can ask for, within Policy.Retries. Zero
+	// leaves it to Policy.Retries.
+	Retries int
+
+	// Made from one of the policy's other settings by Policy.AllRules, and
+	// described by the name of that setting
+	setting bool
+}
+
+// stream is output an attempt produced, named for the log
+type stream struct {
+	name string
+	text string
+}
+
+// outcome is what an attempt left behind for the rules to match
+type outcome struct {
+	exitCode int
+	// The signal that killed the command, if one did
+	signal syscall.Signal
+	// Errors returned to Do have no exit code
+	hasExitCode bool
+	// How long the attempt ran
+	elapsed time.Duration
+	// Set once a FailContinue rule has matched, whatever the command exited
+	// with. exitCode is left as the command's own, for the rules after it.
+	forced  bool
+	err     error
+	streams []stream
+}
+
+func (o outcome) failed() bool {
+	return o.forced || !o.hasExitCode || o.exitCode != 0
+}
+
+// force turns the attempt into a failure. Run gives it
+// ForcedFailureExitCode once the rules are done with it.
+func (o *outcome) force() {
+	o.forced = true
+}
+
+// commandOutcome is the outcome of running a command
+func commandOutcome(exitCode int, signal syscall.Signal, out string, stderr string) outcome {
+	return outcome{
+		exitCode:    exitCode,
+		signal:      signal,
+		hasExitCode: true,
+		streams:     []stream{{name: "stdout", text: out}, {name: "stderr", text: stderr}},
+	}
+}
+
+// errorOutcome is the outcome of a call to Do's function
+func errorOutcome(err error) outcome {
+	return outcome{err: err, streams: []stream{{name: "error", text: err.Error()}}}
+}
+
+// label names the rule in descriptions. A rule made from one of the
+// policy's other settings is named after that setting alone.
+func (r Rule) label(key string) string {
+	if r.setting {
+		return r.Name
+	}
+	if key == "" {
+		return "rule " + strconv.Quote(r.Name)
+	}
+	return "rule " + strconv.Quote(r.Name) + " " + key
+}
+
+// String describes the rule for --explain
+func (r Rule) String() string {
+	var matchers []string
+	if len(r.ExitCodes) > 0 {
+		matchers = append(matchers, fmt.Sprintf("exit_codes %v", r.ExitCodes))
+	}
+	if len(r.Signals) > 0 {
+		names := make([]string, len(r.Signals))
+		for i, sig := range r.Signals {
+			names[i] = SignalName(sig)
+		}
+		matchers = append(matchers, fmt.Sprintf("signals %v", names))
+	}
+	if len(r.Strings) > 0 {
+		texts := make([]string, len(r.Strings))
+		for i, m := range r.Strings {
+			texts[i] = m.String()
+		}
+		matchers = append(matchers, fmt.Sprintf("string_matches %q", texts))
+	}
+	if len(r.Regexps) > 0 {
+		expressions := make([]string, len(r.Regexps))
+		for i, m := range r.Regexps {
+			expressions[i] = m.String()
+		}
+		matchers = append(matchers, fmt.Sprintf("regexp_matches %q", expressions))
+	}
+	if len(r.JSON) > 0 {
+		conditions := make([]string, len(r.JSON))
+		for i, m := range r.JSON {
+			conditions[i] = m.String()
+		}
+		matchers = append(matchers, fmt.Sprintf("json_matches %q", conditions))
+	}
+	if len(r.Errors) > 0 {
+		matchers = append(matchers, fmt.Sprintf("%d error matchers", len(r.Errors)))
+	}
+	condition := "every attempt"
+	if len(matchers) > 0 {
+		condition = strings.Join(matchers, " or ")
+	}
+	if r.Unless {
+		condition = "unless " + condition
+	}
+	if r.If != "" {
+		condition += fmt.Sprintf(", if %q", r.If)
+	}
+	if r.onlyOnFailure() {
+		condition += ", on failure"
+	}
+	return fmt.Sprintf("%s: %s -> %s", r.label(""), condition, r.Action)
+}
+
+// match reports whether the rule, which is rules[i], matches the attempt,
+// and describes how
+func (r Rule) match(sc *scan, i int) (string, bool) {
+	if r.onlyOnFailure() && !sc.failed() {
+		return "", false
+	}
+	description, matched := r.matchAny(sc, i)
+	if r.Unless {
+		return r.label("unless"), !matched
+	}
+	return description, matched
+}
+
+// onlyOnFailure reports whether the rule only matches attempts that
+// failed, as a Retry rule does unless OnSuccess is set
+func (r Rule) onlyOnFailure() bool {
+	return r.OnlyOnFailure || (r.Action == Retry && !r.OnSuccess)
+}
+
+// ifKey is the setting the rule's If is given as
+func (r Rule) ifKey() string {
+	if r.Action == FailContinue {
+		return "fail_if"
+	}
+	return r.Action.String() + "_if"
+}
+
+// describeIf adds the rule's If to the description of a match
+func (r Rule) describeIf(description string) string {
+	if r.If == "" {
+		return description
+	}
+	if !r.hasMatchers() && !r.Unless {
+		return describe(r.label(r.ifKey()), r.If, "")
+	}
+	return description + ", " + describe(r.ifKey(), r.If, "")
+}
+
+func (r Rule) hasMatchers() bool {
+	return len(r.ExitCodes) > 0 || len(r.Signals) > 0 || len(r.Strings) > 0 || len(r.Regexps) > 0 || len(r.JSON) > 0 || len(r.Errors) > 0
+}
+
+func (r Rule) matchAny(sc *scan, i int) (string, bool) {
+	if !r.hasMatchers() {
+		return r.label(""), true
+	}
+	if sc.hasExitCode {
+		for _, code := range r.ExitCodes {
+			if code == sc.exitCode {
+				return describe(r.label("exit_codes"), sc.exitCode, ""), true
+			}
+		}
+	}
+	if sc.signal != 0 {
+		for _, sig := range r.Signals {
+			if sig == sc.signal {
+				return describe(r.label("signals"), SignalName(sig), ""), true
+			}
+		}
+	}
+	if sc.err != nil {
+		for j, matches := range r.Errors {
+			if matches(sc.err) {
+				return fmt.Sprintf("%s[%d]", r.label("errors"), j), true
+			}
+		}
+	}
+	for j, m := range r.Strings {
+		for s, st := range sc.streams {
+			if m.Scope.includes(st.name) && sc.containsString(i, j, s) {
+				return describe(r.label("string_matches"), m.Text, st.name), true
+			}
+		}
+	}
+	for j, m := range r.Regexps {
+		for s, st := range sc.streams {
+			if m.Scope.includes(st.name) && sc.matchesRegexp(i, j, s) {
+				return describe(r.label("regexp_matches"), m.Regexp, st.name), true
+			}
+		}
+	}
+	for _, m := range r.JSON {
+		for s, st := range sc.streams {
+			if m.Scope.includes(st.name) && m.matches(sc.documents(s)) {
+				return describe(r.label("json_matches"), m.text, st.name), true
+			}
+		}
+	}
+	return "", false
+}
+
+// ruleBackoff keeps count of the retries each rule has asked for, and the
+// expressions they wait by
+type ruleBackoff struct {
+	formulas []*formula
+	retries  []int
+}
+
+// compileRules compiles the expression of every rule that has one, before
+// the first attempt, as compileFormula does for the policy's
+func compileRules(policy Policy, rules []Rule, random *rand.Rand) (*ruleBackoff, error) {
+	compiled := &ruleBackoff{
+		formulas: make([]*formula, len(rules)),
+		retries:  make([]int, len(rules)),
+	}
+	for i, r := range rules {
+		if r.Expression == "" {
+			continue
+		}
+		rulePolicy := policy
+		rulePolicy.Expression = r.Expression
+		f, err := compileFormula(rulePolicy, random)
+		if err != nil {
+			return nil, err
+		}
+		compiled.formulas[i] = f
+	}
+	return compiled, nil
+}
+
+// retry counts a retry asked for by rule i, returning false once the rule
+// has run out of retries. It returns the formula to wait by and the retry
+// number to give it, which are f and xIncrement unless the rule has its own
+// expression. An i of -1 is a retry not asked for by a rule, such as after
+// a timeout.
+func (compiled *ruleBackoff) retry(rules []Rule, i int, f *formula, xIncrement int) (*formula, int, bool) {
+	if i < 0 {
+		return f, xIncrement, true
+	}
+	compiled.retries[i]++
+	r := rules[i]
+	if r.Retries > 0 && compiled.retries[i] > r.Retries {
+		log.Warning("Rule", strconv.Quote(r.Name), "has used all", r.Retries, "of its retries")
+		return nil, 0, false
+	}
+	if compiled.formulas[i] != nil {
+		return compiled.formulas[i], compiled.retries[i], true
+	}
+	return f, xIncrement, true
+}
+
+// ParseRule builds a Rule from settings named as they are in the INI file:
+// exit_codes, signals, string_matches, regexp_matches, json_matches,
+// unless, only_on_failure, on_success, action, retry_if, succeed_if,
+// fail_if, expression and retries. Only one of retry_if, succeed_if and fail_if
+// can be given, and action must agree with it.
+func ParseRule(name string, settings map[string]string) (Rule, error) {
+	rule := Rule{Name: name}
+	var err error
+	ifKey := ""
+	for _, key := range []string{"retry_if", "succeed_if", "fail_if"} {
+		value, ok := settings[key]
+		if !ok {
+			continue
+		}
+		if ifKey != "" {
+			return rule, fmt.Errorf("rule %q: %w", name, &ValueError{Setting: ifKey, Value: key, Expected: "only one of retry_if, succeed_if and fail_if"})
+		}
+		ifKey, rule.If = key, value
+		rule.Action, _ = ParseAction(strings.TrimSuffix(key, "_if"))
+	}
+	for key, value := range settings {
+		switch key {
+		case "exit_codes":
+			rule.ExitCodes, err = ParseExitCodes(value)
+		case "signals":
+			rule.Signals, err = ParseSignals(value)
+		case "string_matches":
+			rule.Strings, err = ParseStringMatchers(value)
+		case "regexp_matches":
+			rule.Regexps, err = ParseRegexpMatchers(value)
+		case "json_matches":
+			rule.JSON, err = ParseJSONMatchers(value)
+		case "unless":
+			rule.Unless, err = strconv.ParseBool(strings.TrimSpace(value))
+		case "only_on_failure":
+			rule.OnlyOnFailure, err = strconv.ParseBool(strings.TrimSpace(value))
+		case "on_success":
+			rule.OnSuccess, err = strconv.ParseBool(strings.TrimSpace(value))
+		case "action":
+			var action Action
+			action, err = ParseAction(value)
+			if err == nil && ifKey != "" && action != rule.Action {
+				err = &ValueError{Setting: "action", Value: value, Expected: rule.Action.String() + ", as " + ifKey + " is given"}
+			}
+			rule.Action = action
+		case "retry_if", "succeed_if", "fail_if":
+		case "expression":
+			rule.Expression = value
+		case "retries":
+			rule.Retries, err = strconv.Atoi(strings.TrimSpace(value))
+		default:
+			err = &ValueError{Setting: "rule " + strconv.Quote(name), Value: key, Expected: "exit_codes, signals, string_matches, regexp_matches, json_matches, unless, only_on_failure, on_success, action, retry_if, succeed_if, fail_if, expression or retries"}
+		}
+		if err != nil {
+			return rule, fmt.Errorf("rule %q: %w", name, err)
+		}
+	}
+	return rule, nil
+}
+
+// ParseRuleSpec builds a Rule from the settings ParseRule takes written as
+// shell quoted key=value pairs, such as
+//
+//	name=quota string_matches="Quota exceeded" expression="100+5*r" retries=10
+func ParseRuleSpec(spec string) (Rule, error) {
+	words, err := shellwords.Parse(spec)
+	if err != nil {
+		return Rule{}, &CommandError{Command: spec, Err: err}
+	}
+	name := ""
+	settings := make(map[string]string, len(words))
+	for _, word := range words {
+		key, value, ok := strings.Cut(word, "=")
+		if !ok {
+			return Rule{}, &ValueError{Setting: "rule", Value: word, Expected: "key=value"}
+		}
+		if key == "name" {
+			name = value
+			continue
+		}
+		settings[key] = value
+	}
+	return ParseRule(name, settings)
+}
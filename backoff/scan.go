@@ -0,0 +1,287 @@
+/*
+* Copyright 2020-present, Synopsys, Inc. * All rights reserved.
+*
+* This source code is licensed under the Apache-2.0 license found in
+* the LICENSE file in the root directory of this source tree. */
+package backoff
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// MatchWindow limits the matchers to the end of each stream, so that
+// commands with a lot of output take no longer to classify than those with
+// a little. Zero Lines or Bytes leaves that unlimited.
+type MatchWindow struct {
+	Lines int
+	Bytes int
+}
+
+func (w MatchWindow) String() string {
+	var limits []string
+	if w.Lines > 0 {
+		limits = append(limits, fmt.Sprintf("%d lines", w.Lines))
+	}
+	if w.Bytes > 0 {
+		limits = append(limits, fmt.Sprintf("%d bytes", w.Bytes))
+	}
+	if len(limits) == 0 {
+		return "all output"
+	}
+	return strings.Join(limits, ", ")
+}
+
+// tail cuts text down to the window. A trailing newline does not start
+// another line.
+func (w MatchWindow) tail(text string) string {
+	if w.Lines > 0 {
+		end := len(text)
+		if strings.HasSuffix(text, "\n") {
+			end--
+		}
+		for n := 0; n < w.Lines && end >= 0; n++ {
+			end = strings.LastIndexByte(text[:end], '\n')
+		}
+		if end >= 0 {
+			text = text[end+1:]
+		}
+	}
+	if w.Bytes > 0 && len(text) > w.Bytes {
+		start := len(text) - w.Bytes
+		for start < len(text) && !utf8.RuneStart(text[start]) {
+			start++
+		}
+		text = text[start:]
+	}
+	return text
+}
+
+// ParseMatchWindow reads a comma delimited list of limits such as
+// "200 lines" or "65536 bytes". Empty matches all output.
+func ParseMatchWindow(s string) (MatchWindow, error) {
+	var w MatchWindow
+	fields, err := readList(s)
+	if err != nil {
+		return w, err
+	}
+	for _, field := range fields {
+		invalid := &ValueError{Setting: "match_window", Value: field, Expected: `a number of lines or bytes, such as "200 lines"`}
+		words := strings.Fields(field)
+		if len(words) != 2 {
+			return w, invalid
+		}
+		n, err := strconv.Atoi(words[0])
+		if err != nil || n < 0 {
+			return w, invalid
+		}
+		switch strings.ToLower(words[1]) {
+		case "line", "lines":
+			w.Lines = n
+		case "byte", "bytes":
+			w.Bytes = n
+		default:
+			return w, invalid
+		}
+	}
+	return w, nil
+}
+
+// matcherSet holds every string and regexp the rules look for, so the
+// output of an attempt can be searched for all of them at once rather than
+// once per matcher, along with the rules' compiled conditions
+type matcherSet struct {
+	// The strings, searched for together
+	strings *ahoCorasick
+	// ruleStrings[rule][i] is which of the strings Rules[rule].Strings[i] is
+	ruleStrings [][]int
+
+	// Every regexp joined into one, with a group around each, so the groups
+	// that took part in its matches say which regexps matched. nil when
+	// none could be joined.
+	regexps *regexp.Regexp
+	// The regexps, and the group each is in
+	patterns []*regexp.Regexp
+	groups   []int
+	// alone[id] is whether regexp id could not be joined with the others,
+	// and so is run on its own
+	alone []bool
+	// ruleRegexps[rule][i] is which of the regexps Rules[rule].Regexps[i] is
+	ruleRegexps [][]int
+
+	// conditions[rule] is Rules[rule].If compiled, or nil
+	conditions []*condition
+}
+
+// newMatcherSet gathers the matchers of the rules, and compiles their
+// conditions as compileRules does their expressions
+func newMatcherSet(rules []Rule) (*matcherSet, error) {
+	set := &matcherSet{
+		ruleStrings: make([][]int, len(rules)),
+		ruleRegexps: make([][]int, len(rules)),
+		conditions:  make([]*condition, len(rules)),
+	}
+	ids := make(map[string]int)
+	regexpIDs := make(map[string]int)
+	var texts []string
+	for i, r := range rules {
+		for _, m := range r.Strings {
+			id, ok := ids[m.Text]
+			if !ok {
+				id = len(texts)
+				ids[m.Text] = id
+				texts = append(texts, m.Text)
+			}
+			set.ruleStrings[i] = append(set.ruleStrings[i], id)
+		}
+		for _, m := range r.Regexps {
+			id, ok := regexpIDs[m.Regexp.String()]
+			if !ok {
+				id = len(set.patterns)
+				regexpIDs[m.Regexp.String()] = id
+				set.patterns = append(set.patterns, m.Regexp)
+			}
+			set.ruleRegexps[i] = append(set.ruleRegexps[i], id)
+		}
+		if r.If != "" {
+			c, err := compileCondition(r.If)
+			if err != nil {
+				return nil, err
+			}
+			set.conditions[i] = c
+		}
+	}
+	set.strings = newAhoCorasick(texts)
+	set.joinRegexps()
+	return set, nil
+}
+
+// joinPatterns joins the regexps ids of patterns into one, with a group
+// around each. nil when there are none.
+func joinPatterns(patterns []*regexp.Regexp, ids []int) (*regexp.Regexp, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	expressions := make([]string, len(ids))
+	for i, id := range ids {
+		expressions[i] = "(" + patterns[id].String() + ")"
+	}
+	return regexp.Compile(strings.Join(expressions, "|"))
+}
+
+// joinRegexps joins the regexps so they can be run in one pass. Every
+// regexp compiled on its own, so only joining them can fail, such as by
+// nesting too deeply. Then each is tried in turn, and those that cannot be
+// joined with the ones before are left to run on their own.
+func (set *matcherSet) joinRegexps() {
+	set.groups = make([]int, len(set.patterns))
+	set.alone = make([]bool, len(set.patterns))
+	ids := make([]int, len(set.patterns))
+	for id := range ids {
+		ids[id] = id
+	}
+	joined, err := joinPatterns(set.patterns, ids)
+	if err != nil {
+		log.Warning("Unable to search for every regexp together, so searching for those that cannot be joined on their own: ", err)
+		ids = ids[:0]
+		for id := range set.patterns {
+			if _, err := joinPatterns(set.patterns, append(ids, id)); err != nil {
+				set.alone[id] = true
+			} else {
+				ids = append(ids, id)
+			}
+		}
+		joined, _ = joinPatterns(set.patterns, ids)
+	}
+	set.regexps = joined
+	group := 1
+	for _, id := range ids {
+		set.groups[id] = group
+		group += 1 + set.patterns[id].NumSubexp()
+	}
+}
+
+// matchRegexps runs the joined regexps over text in one pass, and those
+// that could not be joined on their own. A regexp that matched is marked
+// in matched. found is whether any of the joined ones might have, as
+// otherwise none of them match text.
+func (set *matcherSet) matchRegexps(text string) (matched []bool, found bool) {
+	matched = make([]bool, len(set.patterns))
+	if set.regexps != nil {
+		for _, indexes := range set.regexps.FindAllStringSubmatchIndex(text, -1) {
+			found = true
+			for id, group := range set.groups {
+				if !set.alone[id] && indexes[2*group] >= 0 {
+					matched[id] = true
+				}
+			}
+		}
+	}
+	for id, pattern := range set.patterns {
+		if set.alone[id] {
+			matched[id] = pattern.MatchString(text)
+		}
+	}
+	return matched, found
+}
+
+// scan is an attempt's outcome with its streams cut down to the match
+// window and searched for every string and regexp, which the rules then
+// look up
+type scan struct {
+	outcome
+	set *matcherSet
+	// contains[s][id] is whether stream s contains string id
+	contains [][]bool
+	// matched[s][id] is whether regexp id matched stream s, and found[s]
+	// whether any joined one did
+	matched [][]bool
+	found   []bool
+	// The JSON in each stream, read the first time a rule asks for it
+	json [][]interface{}
+	read []bool
+}
+
+func (set *matcherSet) scan(window MatchWindow, o outcome) *scan {
+	sc := &scan{outcome: o, set: set}
+	sc.streams = make([]stream, len(o.streams))
+	sc.json = make([][]interface{}, len(o.streams))
+	sc.read = make([]bool, len(o.streams))
+	for s, st := range o.streams {
+		st.text = window.tail(st.text)
+		sc.streams[s] = st
+		sc.contains = append(sc.contains, set.strings.contains(st.text))
+		matched, found := set.matchRegexps(st.text)
+		sc.matched = append(sc.matched, matched)
+		sc.found = append(sc.found, found)
+	}
+	return sc
+}
+
+// containsString reports whether stream s contains Rules[rule].Strings[i]
+func (sc *scan) containsString(rule int, i int, s int) bool {
+	return sc.contains[s][sc.set.ruleStrings[rule][i]]
+}
+
+// matchesRegexp reports whether Rules[rule].Regexps[i] matches stream s.
+// Where two joined regexps match the same text only the first is marked,
+// so one that is not is run on its own when another matched.
+func (sc *scan) matchesRegexp(rule int, i int, s int) bool {
+	id := sc.set.ruleRegexps[rule][i]
+	if !sc.matched[s][id] && sc.found[s] && !sc.set.alone[id] {
+		sc.matched[s][id] = sc.set.patterns[id].MatchString(sc.streams[s].text)
+	}
+	return sc.matched[s][id]
+}
+
+// documents is the JSON in stream s
+func (sc *scan) documents(s int) []interface{} {
+	if !sc.read[s] {
+		sc.json[s] = parseDocuments(sc.streams[s].text)
+		sc.read[s] = true
+	}
+	return sc.json[s]
+}
@@ -0,0 +1,190 @@
+/*
+* Copyright 2020-present, Synopsys, Inc. * All rights reserved.
+*
+* This source code is licensed under the Apache-2.0 license found in
+* the LICENSE file in the root directory of this source tree. */
+package backoff
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/Knetic/govaluate"
+)
+
+// The computation of how long to wait between attempts is shared by Run
+// and Do, so a Policy backs off the same way whatever it is retrying.
+
+// exhausted reports whether the retry loop should give up now that
+// xIncrement retries have been asked for and elapsed time has passed since
+// the first attempt started
+func exhausted(policy Policy, xIncrement int, elapsed time.Duration) (Reason, bool) {
+	log.Debug("Elapsed Time:", elapsed)
+	if xIncrement > policy.Retries && policy.Retries != -1 {
+		return RetriesExhausted, true
+	}
+
+	log.Debug("Time check:", elapsed, ">=", policy.Duration, "?")
+	if elapsed >= policy.Duration && policy.Duration >= 0 {
+		return DurationExhausted, true
+	}
+	return Succeeded, false
+}
+
+// loopState is what the expression gets to know about the retry loop
+type loopState struct {
+	// The retry about to be made, 1 based
+	xIncrement int
+	// Time since the first attempt started
+	elapsed time.Duration
+	// How long the last attempt ran for
+	lastDuration time.Duration
+	// The exit code of the last attempt, after matchers were applied
+	exitCode int
+	// How long the loop waited before the last attempt
+	prevSleep time.Duration
+	// How long the output of the last attempt asked to wait, when hinted
+	hint   time.Duration
+	hinted bool
+}
+
+func newLoopState(elapsed time.Duration, xIncrement int, attempts []Attempt, exitCode int) loopState {
+	state := loopState{
+		xIncrement: xIncrement,
+		elapsed:    elapsed,
+		exitCode:   exitCode,
+	}
+	if len(attempts) > 0 {
+		state.lastDuration = attempts[len(attempts)-1].Elapsed
+	}
+	if len(attempts) > 1 {
+		state.prevSleep = attempts[len(attempts)-2].Sleep
+	}
+	return state
+}
+
+// expressionParameters are the variables available to the expression
+func expressionParameters(policy Policy, state loopState, r float64) map[string]interface{} {
+	remaining := -1.0
+	if policy.Duration >= 0 {
+		remaining = (policy.Duration - state.elapsed).Seconds()
+	}
+	hint := -1.0
+	if state.hinted {
+		hint = state.hint.Seconds()
+	}
+	parameters := make(map[string]interface{}, 10)
+	parameters["x"] = state.xIncrement - 1
+	parameters["i"] = state.xIncrement
+	parameters["r"] = r
+	parameters["elapsed"] = state.elapsed.Seconds()
+	parameters["last_duration"] = state.lastDuration.Seconds()
+	parameters["exit_code"] = state.exitCode
+	parameters["remaining"] = remaining
+	parameters["retries"] = policy.Retries
+	parameters["prev_sleep"] = state.prevSleep.Seconds()
+	parameters["hint"] = hint
+	return parameters
+}
+
+// formula is the policy's expression, or strategy, compiled once before the
+// first attempt
+type formula struct {
+	text       string
+	expression *govaluate.EvaluableExpression
+	random     *rand.Rand
+}
+
+// compileFormula compiles the policy's expression and evaluates it once as
+// if the first retry were being made, so that a mistake in it is found
+// before the command is run rather than after it first fails. The
+// expression's 'r' and rand() draw from random.
+func compileFormula(policy Policy, random *rand.Rand) (*formula, error) {
+	text, err := policy.expression()
+	if err != nil {
+		return nil, &FormulaError{Expression: policy.Strategy.Name, Compile: true, Err: err}
+	}
+	f := &formula{text: text, random: random}
+	if f.expression, err = compileExpression(text, f.random.Float64); err != nil {
+		log.Error("Formula cannot be evaluated!")
+		return nil, err
+	}
+	if _, err := f.evaluate(policy, loopState{xIncrement: 1}); err != nil {
+		log.Error("Formula failed to be evaluate!")
+		return nil, err
+	}
+	return f, nil
+}
+
+// evaluate returns the number of seconds the formula gives for state
+func (f *formula) evaluate(policy Policy, state loopState) (float64, error) {
+	parameters := expressionParameters(policy, state, f.random.Float64())
+
+	log.Debug("Expression:", f.text, "x:", state.xIncrement-1)
+	evaluated, err := f.expression.Evaluate(parameters)
+	if err != nil {
+		return 0, &FormulaError{Expression: f.text, Err: err}
+	}
+	value, ok := evaluated.(float64)
+	if !ok {
+		return 0, &FormulaError{Expression: f.text, Err: fmt.Errorf("expected a number of seconds, got %v", evaluated)}
+	}
+	return value, nil
+}
+
+// nextSleep evaluates the formula for the next retry, applies any retry
+// after hint, and clamps the result so the wait does not run past
+// policy.Duration
+func (f *formula) nextSleep(policy Policy, state loopState) (time.Duration, error) {
+	sleepForD, err := f.wait(policy, state)
+	if err != nil {
+		return 0, err
+	}
+	if state.hinted && (policy.RetryAfterOverrides || state.hint > sleepForD) {
+		log.Debug("Using the retry after hint of", state.hint, "rather than", sleepForD)
+		sleepForD = state.hint
+	}
+	sleepForD = clampToDuration(policy, state.elapsed, sleepForD)
+	log.Info("Time to sleeping for before retrying: ", sleepForD)
+	return sleepForD, nil
+}
+
+// wait evaluates the formula for the next retry. A negative or NaN result
+// is not an error, as it may only happen for some attempts; it is logged
+// and the retry is made straight away.
+func (f *formula) wait(policy Policy, state loopState) (time.Duration, error) {
+	value, err := f.evaluate(policy, state)
+	if err != nil {
+		log.Error("Formula failed to be evaluate!")
+		return 0, err
+	}
+
+	log.Debug("Formula calculation:", value)
+	if math.IsNaN(value) || value < 0 {
+		log.Warning("Formula gave", value, "seconds for retry", state.xIncrement, "so retrying without waiting")
+		value = 0
+	}
+
+	//time.Duration will round to whatever it is multiplied by... do not switch to time.Second
+	sleepForD := time.Duration(math.MaxInt64)
+	if value*1000 < float64(math.MaxInt64/int64(time.Millisecond)) {
+		sleepForD = time.Duration(value*1000) * time.Millisecond
+	}
+	log.Debug("Planning to sleep for", sleepForD)
+	return sleepForD, nil
+}
+
+// clampToDuration shortens a wait that would run past policy.Duration, given
+// elapsed time has already passed since the first attempt started
+func clampToDuration(policy Policy, elapsed time.Duration, sleepForD time.Duration) time.Duration {
+	// If our max wait is 600 seconds, we've waited 596, and our next wait duration is 30,
+	// do some math so we don't go over 600 seconds
+	log.Debug("Overrun check:", sleepForD, ">=", policy.Duration-elapsed)
+	if sleepForD >= policy.Duration-elapsed && policy.Duration >= 0 {
+		sleepForD = policy.Duration - elapsed
+		log.Debug("Adjusted Sleep Due To Max Overrun:", sleepForD)
+	}
+	return sleepForD
+}
@@ -0,0 +1,142 @@
+/*
+* Copyright 2020-present, Synopsys, Inc. * All rights reserved.
+*
+* This source code is licensed under the Apache-2.0 license found in
+* the LICENSE file in the root directory of this source tree. */
+package backoff
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// SignalError is used as the cause when cancelling the context passed to
+// Run, to have the signal eb received passed on to the running command
+type SignalError struct {
+	Signal syscall.Signal
+}
+
+func (e *SignalError) Error() string {
+	return "received " + e.Signal.String()
+}
+
+// ExitCode is the conventional exit code of a process killed by the signal
+func (e *SignalError) ExitCode() int {
+	return 128 + int(e.Signal)
+}
+
+type killKey struct{}
+
+// WithKill returns a copy of ctx, and a function that kills a command Run
+// is stopping straight away rather than giving it Policy.KillGracePeriod
+// to exit, such as when eb is interrupted a second time
+func WithKill(ctx context.Context) (context.Context, func()) {
+	kill := make(chan struct{})
+	var once sync.Once
+	return context.WithValue(ctx, killKey{}, kill), func() { once.Do(func() { close(kill) }) }
+}
+
+// killOnRequest kills a command that is being stopped when the function
+// from WithKill is called, until the command exits
+func killOnRequest(ctx context.Context, cmd *exec.Cmd, exited <-chan struct{}) {
+	kill, _ := ctx.Value(killKey{}).(chan struct{})
+	if kill == nil {
+		return
+	}
+	select {
+	case <-kill:
+		log.Warning("Killing command without waiting for it to exit")
+		terminate(cmd, syscall.SIGKILL)
+	case <-exited:
+	}
+}
+
+// stopSignal decides which signal stops an attempt once ctx is done: the
+// signal eb received if there was one, otherwise SIGTERM, or SIGKILL when
+// there is no grace period to exit in
+func stopSignal(ctx context.Context, policy Policy) syscall.Signal {
+	var signalErr *SignalError
+	if errors.As(context.Cause(ctx), &signalErr) {
+		return signalErr.Signal
+	}
+	if policy.KillGracePeriod > 0 {
+		return syscall.SIGTERM
+	}
+	return syscall.SIGKILL
+}
+
+// signalNames are the signals that can be given by name. Any other can be
+// given by number.
+var signalNames = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGILL":  syscall.SIGILL,
+	"SIGTRAP": syscall.SIGTRAP,
+	"SIGABRT": syscall.SIGABRT,
+	"SIGBUS":  syscall.SIGBUS,
+	"SIGFPE":  syscall.SIGFPE,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGSEGV": syscall.SIGSEGV,
+	"SIGPIPE": syscall.SIGPIPE,
+	"SIGALRM": syscall.SIGALRM,
+	"SIGTERM": syscall.SIGTERM,
+}
+
+// SignalName gives the name of sig, such as "SIGKILL"
+func SignalName(sig syscall.Signal) string {
+	for name, s := range signalNames {
+		if s == sig {
+			return name
+		}
+	}
+	return "signal " + strconv.Itoa(int(sig))
+}
+
+// ParseSignals converts a comma delimited list of signals, given by name
+// with or without the SIG prefix, such as "SIGKILL,SEGV", or by number
+func ParseSignals(s string) ([]syscall.Signal, error) {
+	log.Debug("Converting to signal array:", s)
+	fields, err := readList(s)
+	if err != nil {
+		return nil, err
+	}
+	var signals []syscall.Signal
+	for _, field := range fields {
+		name := strings.ToUpper(strings.TrimSpace(field))
+		if n, err := strconv.Atoi(name); err == nil && n > 0 {
+			signals = append(signals, syscall.Signal(n))
+			continue
+		}
+		if !strings.HasPrefix(name, "SIG") {
+			name = "SIG" + name
+		}
+		sig, ok := signalNames[name]
+		if !ok {
+			return nil, &ValueError{Setting: "signals", Value: field, Expected: "a signal name such as SIGKILL, or a number"}
+		}
+		signals = append(signals, sig)
+	}
+	return signals, nil
+}
+
+// exitStatus is the exit code a finished command returned, and the signal
+// that killed it if one did. A command killed by a signal is given 128 plus
+// the signal number, as a shell would, rather than the -1 os.ProcessState
+// gives. A command that could not be started is given
+// InternalErrorExitCode.
+func exitStatus(state *os.ProcessState) (int, syscall.Signal) {
+	if state == nil {
+		return InternalErrorExitCode, 0
+	}
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal()), status.Signal()
+	}
+	return state.ExitCode(), 0
+}
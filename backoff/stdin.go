@@ -0,0 +1,193 @@
+/*
+* Copyright 2020-present, Synopsys, Inc. * All rights reserved.
+*
+* This source code is licensed under the Apache-2.0 license found in
+* the LICENSE file in the root directory of this source tree. */
+package backoff
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+)
+
+// DefaultStdinMemoryLimit is how much of stdin is held in memory when
+// Policy.StdinMemoryLimit is not set
+const DefaultStdinMemoryLimit = 4 << 20
+
+// spoolChunk is the most read from stdin, or given to an attempt, at once
+const spoolChunk = 32 << 10
+
+// spool keeps a copy of stdin so the same input can be replayed to every
+// attempt. Stdin is only read as attempts ask for more of it, so a command
+// that never reads its input is not held up waiting for the end of it.
+// Input over the memory limit goes to a temporary file.
+type spool struct {
+	source io.Reader
+	limit  int64
+	// Asks pump to read more of source
+	demand chan struct{}
+
+	mu   sync.Mutex
+	data []byte
+	file *os.File
+	size int64
+	// Closed, and replaced, whenever more has been read
+	more chan struct{}
+	// Set once source is used up, or cannot be read
+	err    error
+	closed bool
+}
+
+func newSpool(r io.Reader, limit int64) *spool {
+	if limit <= 0 {
+		limit = DefaultStdinMemoryLimit
+	}
+	s := &spool{source: r, limit: limit, demand: make(chan struct{}, 1), more: make(chan struct{})}
+	go s.pump()
+	return s
+}
+
+// pump reads source when asked to. A read that never returns, such as from
+// a pipe that is never closed, only holds up this goroutine.
+func (s *spool) pump() {
+	buf := make([]byte, spoolChunk)
+	for range s.demand {
+		n, err := s.source.Read(buf)
+		s.mu.Lock()
+		if n > 0 && !s.closed {
+			if recordErr := s.record(buf[:n]); recordErr != nil && err == nil {
+				err = recordErr
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Warning("Unable to read stdin: ", err)
+			}
+			s.err = err
+		}
+		close(s.more)
+		s.more = make(chan struct{})
+		s.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// record keeps input read from source, spooling what is over the limit
+func (s *spool) record(p []byte) error {
+	if room := s.limit - int64(len(s.data)); room > 0 {
+		n := int(min(room, int64(len(p))))
+		s.data = append(s.data, p[:n]...)
+		s.size += int64(n)
+		p = p[n:]
+	}
+	if len(p) == 0 {
+		return nil
+	}
+	if s.file == nil {
+		file, err := os.CreateTemp("", "eb-stdin-")
+		if err != nil {
+			return err
+		}
+		log.Debug("Spooling stdin over ", s.limit, " bytes to ", file.Name())
+		s.file = file
+	}
+	if _, err := s.file.Write(p); err != nil {
+		return err
+	}
+	s.size += int64(len(p))
+	return nil
+}
+
+// next returns the input from offset that has been read so far. When there
+// is none it asks for more, and returns a channel that is closed once there
+// is. err is set once there will be no more.
+func (s *spool) next(offset int64) ([]byte, <-chan struct{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if offset < int64(len(s.data)) {
+		end := min(int64(len(s.data)), offset+spoolChunk)
+		return append([]byte(nil), s.data[offset:end]...), nil, nil
+	}
+	if offset < s.size {
+		chunk := make([]byte, min(s.size-offset, spoolChunk))
+		n, err := s.file.ReadAt(chunk, offset-int64(len(s.data)))
+		if n == 0 && err != nil {
+			return nil, nil, err
+		}
+		return chunk[:n], nil, nil
+	}
+	if s.err != nil || s.closed {
+		return nil, nil, io.EOF
+	}
+	select {
+	case s.demand <- struct{}{}:
+	default:
+	}
+	return nil, s.more, nil
+}
+
+// feed gives the input, from the start, to a pipe for the next attempt to
+// read. Feeding stops when stdin runs out, ctx is done, or stop is called
+// once the attempt is over.
+func (s *spool) feed(ctx context.Context) (*os.File, func(), error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	var once sync.Once
+	closeWriter := func() { once.Do(func() { w.Close() }) }
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// Closing the pipe is how the attempt sees the end of the input
+		defer closeWriter()
+		var offset int64
+		for {
+			chunk, more, err := s.next(offset)
+			if err != nil {
+				return
+			}
+			if len(chunk) > 0 {
+				if _, err := w.Write(chunk); err != nil {
+					return
+				}
+				offset += int64(len(chunk))
+				continue
+			}
+			select {
+			case <-more:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	stop := func() {
+		cancel()
+		// The attempt may have exited without reading everything written
+		r.Close()
+		closeWriter()
+		<-done
+	}
+	return r, stop, nil
+}
+
+// Close removes the temporary file, if there is one
+func (s *spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.demand)
+	if s.file == nil {
+		return nil
+	}
+	s.file.Close()
+	return os.Remove(s.file.Name())
+}
@@ -0,0 +1,128 @@
+/*
+* Copyright 2020-present, Synopsys, Inc. * All rights reserved.
+*
+* This source code is licensed under the Apache-2.0 license found in
+* the LICENSE file in the root directory of this source tree. */
+package backoff
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Strategy is a named backoff formula. It is turned into an expression, so
+// it is evaluated exactly as Policy.Expression would be.
+type Strategy struct {
+	// One of StrategyNames
+	Name string
+	// Seconds to wait before the first retry. Defaults to 1.
+	Base float64
+	// How quickly the wait grows. Defaults to Base for linear, 3 for
+	// decorrelated-jitter and 2 for the others that use it.
+	Factor float64
+	// The longest wait, in seconds, before any jitter is added. Zero or less
+	// for no cap.
+	Cap float64
+	// The shortest wait, in seconds
+	Min float64
+}
+
+// strategies gives the expression for each strategy, in terms of base (b),
+// factor (f) and cap (c). The growth is capped before jitter is applied so
+// that jitter strategies still spread out their retries at the cap.
+var strategies = map[string]func(b, f, c string) string{
+	"constant": func(b, f, c string) string {
+		return b
+	},
+	"linear": func(b, f, c string) string {
+		return capped(fmt.Sprintf("%s + %s*x", b, f), c)
+	},
+	"exponential": func(b, f, c string) string {
+		return capped(fmt.Sprintf("%s * pow(%s, x)", b, f), c)
+	},
+	"full-jitter": func(b, f, c string) string {
+		return fmt.Sprintf("rand(0, %s)", capped(fmt.Sprintf("%s * pow(%s, x)", b, f), c))
+	},
+	"equal-jitter": func(b, f, c string) string {
+		t := capped(fmt.Sprintf("%s * pow(%s, x)", b, f), c)
+		return fmt.Sprintf("%s/2 + rand(0, %s/2)", t, t)
+	},
+	"decorrelated-jitter": func(b, f, c string) string {
+		return capped(fmt.Sprintf("rand(%s, max(%s, prev_sleep * %s))", b, b, f), c)
+	},
+	// Binet's formula gives the i'th Fibonacci number
+	"fibonacci": func(b, f, c string) string {
+		return capped(fmt.Sprintf("%s * floor(pow(1.618033988749895, i) / 2.23606797749979 + 0.5)", b), c)
+	},
+}
+
+func capped(expression, c string) string {
+	if c == "" {
+		return expression
+	}
+	return fmt.Sprintf("min(%s, %s)", c, expression)
+}
+
+// StrategyNames returns the names of the built in strategies
+func StrategyNames() []string {
+	return []string{"constant", "linear", "exponential", "full-jitter", "equal-jitter", "decorrelated-jitter", "fibonacci"}
+}
+
+// ParseStrategy checks name is one of StrategyNames, ignoring case
+func ParseStrategy(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if _, ok := strategies[name]; !ok && name != "" {
+		return "", &ValueError{Setting: "strategy", Value: name, Expected: "one of " + strings.Join(StrategyNames(), ", ")}
+	}
+	return name, nil
+}
+
+// Expression returns the govaluate expression the strategy computes its
+// wait with
+func (s Strategy) Expression() (string, error) {
+	name, err := ParseStrategy(s.Name)
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		return "0", nil
+	}
+	base := s.Base
+	if base <= 0 {
+		base = 1
+	}
+	factor := s.Factor
+	if factor <= 0 {
+		switch name {
+		case "linear":
+			factor = base
+		case "decorrelated-jitter":
+			factor = 3
+		default:
+			factor = 2
+		}
+	}
+	c := ""
+	if s.Cap > 0 {
+		c = number(s.Cap)
+	}
+	expression := strategies[name](number(base), number(factor), c)
+	if s.Min > 0 {
+		expression = fmt.Sprintf("max(%s, %s)", number(s.Min), expression)
+	}
+	return expression, nil
+}
+
+func number(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// expression is the expression the policy waits by. Expression wins over
+// Strategy when both are set.
+func (p Policy) expression() (string, error) {
+	if p.Expression != "" {
+		return p.Expression, nil
+	}
+	return p.Strategy.Expression()
+}
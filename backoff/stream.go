@@ -0,0 +1,116 @@
+/*
+* Copyright 2020-present, Synopsys, Inc. * All rights reserved.
+*
+* This source code is licensed under the Apache-2.0 license found in
+* the LICENSE file in the root directory of this source tree. */
+package backoff
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// OutputMode chooses when the output of the command is shown
+type OutputMode int
+
+const (
+	// Buffered holds on to the output of each attempt, and only shows the
+	// output of the final attempt (and of failed attempts when
+	// PrintVerboseRetryOnFailure is set)
+	Buffered OutputMode = iota
+	// Streamed shows the output of every attempt as it is produced, while
+	// still capturing it for the matchers
+	Streamed
+)
+
+func (m OutputMode) String() string {
+	if m == Streamed {
+		return "streamed"
+	}
+	return "buffered"
+}
+
+// ParseOutputMode converts "buffered" or "streamed" to an OutputMode
+func ParseOutputMode(s string) (OutputMode, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "buffered":
+		return Buffered, nil
+	case "streamed", "stream":
+		return Streamed, nil
+	}
+	return Buffered, &ValueError{Setting: "output_mode", Value: s, Expected: "buffered or streamed"}
+}
+
+func (p Policy) stdout() io.Writer {
+	if p.Stdout != nil {
+		return p.Stdout
+	}
+	return os.Stdout
+}
+
+func (p Policy) stderr() io.Writer {
+	if p.Stderr != nil {
+		return p.Stderr
+	}
+	return os.Stderr
+}
+
+// attemptWriters returns where the command should write its output for an
+// attempt. The output always goes to out and errOut so it can be matched
+// against, and is also shown as it is produced when streaming.
+func attemptWriters(policy Policy, attempt int, out io.Writer, errOut io.Writer) (io.Writer, io.Writer) {
+	if policy.OutputMode != Streamed {
+		return out, errOut
+	}
+	stdout, stderr := policy.stdout(), policy.stderr()
+	if policy.PrefixOutput {
+		prefix := fmt.Sprintf("[attempt %d] ", attempt)
+		stdout = &prefixWriter{w: stdout, prefix: []byte(prefix)}
+		stderr = &prefixWriter{w: stderr, prefix: []byte(prefix)}
+	}
+	return io.MultiWriter(out, stdout), io.MultiWriter(errOut, stderr)
+}
+
+// showOutput shows the captured output of an attempt. When streaming it
+// has been shown already.
+func showOutput(policy Policy, out string, errOut string) {
+	if policy.OutputMode == Streamed {
+		return
+	}
+	io.WriteString(policy.stderr(), errOut)
+	io.WriteString(policy.stdout(), out)
+}
+
+// prefixWriter writes prefix at the start of every line
+type prefixWriter struct {
+	w       io.Writer
+	prefix  []byte
+	midLine bool
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		if !p.midLine {
+			if _, err := p.w.Write(p.prefix); err != nil {
+				return written, err
+			}
+			p.midLine = true
+		}
+		line := b
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			line = b[:i+1]
+			p.midLine = false
+		}
+		n, err := p.w.Write(line)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[len(line):]
+	}
+	return written, nil
+}
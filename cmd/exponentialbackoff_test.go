@@ -7,17 +7,259 @@
 package cmd
 
 import (
-	"regexp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/blackducksoftware/exponential-backoff-tool/backoff"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
-func TestExponentialBackoff(*testing.T) {
-	var retryCodes []int
-	var retryStrings []string
-	var retryRegexps []*regexp.Regexp
-	var command []string
-	command = append(command, "echo")
-	command = append(command, "hi")
+// parseFlags puts every flag back to its default, then parses args as eb
+// would
+func parseFlags(t *testing.T, args ...string) *cobra.Command {
+	t.Helper()
+	configureLogging(false, false)
+	rootCmd.PersistentFlags().VisitAll(func(f *pflag.Flag) {
+		if slice, ok := f.Value.(pflag.SliceValue); ok {
+			slice.Replace(nil)
+		} else {
+			f.Value.Set(f.DefValue)
+		}
+		f.Changed = false
+	})
+	if err := rootCmd.ParseFlags(args); err != nil {
+		t.Fatal(err)
+	}
+	return rootCmd
+}
+
+// writeIni writes an INI file for the test to load
+func writeIni(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "eb.ini")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	cmd := parseFlags(t, "-e", "1", "-r", "4", "-d", "10", "-a")
+	policy, err := loadParameters(cmd, "echo", writeIni(t, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := backoff.Run(context.Background(), policy, []string{"echo", "hi"})
+	if result.ExitCode != 0 || len(result.Attempts) != 1 {
+		t.Errorf("expected echo to succeed first time, got %d after %d attempts", result.ExitCode, len(result.Attempts))
+	}
+}
+
+func TestLoadParameters(t *testing.T) {
+	ini := `
+expression: "5"
+retries: 3
+retry_on_all: true
+
+[echo]
+retries: 4
+retry_on_string_matches: "timeout"
+
+[other]
+retries: 9
+`
+	for _, test := range []struct {
+		name       string
+		args       []string
+		command    string
+		expression string
+		retries    int
+		retryOnAll bool
+		strings    int
+	}{
+		{"global", nil, "true", "5", 3, false, 0},
+		{"local", nil, "echo", "5", 4, false, 1},
+		{"flags", []string{"-e", "7", "-r", "2", "-a", "-s", "a,b"}, "echo", "7", 2, true, 2},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			policy, err := loadParameters(parseFlags(t, test.args...), test.command, writeIni(t, ini))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if policy.Expression != test.expression || policy.Retries != test.retries {
+				t.Errorf("expected expression %q and %d retries, got %q and %d", test.expression, test.retries, policy.Expression, policy.Retries)
+			}
+			// retry_on_all and the matchers are only read from the local section
+			if policy.RetryOnAll != test.retryOnAll || len(policy.RetryOnStrings) != test.strings {
+				t.Errorf("expected retry_on_all %v and %d strings, got %v and %d", test.retryOnAll, test.strings, policy.RetryOnAll, len(policy.RetryOnStrings))
+			}
+		})
+	}
+}
+
+func TestStrategyPrecedence(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		ini        string
+		args       []string
+		expression string
+	}{
+		{"expression wins in the same section", "[echo]\nexpression: \"5\"\nstrategy: \"linear\"\n", nil, "5"},
+		{"expression wins globally", "expression: \"5\"\nstrategy: \"linear\"\n", nil, "5"},
+		{"local strategy over global expression", "expression: \"5\"\n[echo]\nstrategy: \"linear\"\n", nil, ""},
+		{"local expression over global strategy", "strategy: \"linear\"\n[echo]\nexpression: \"5\"\n", nil, "5"},
+		{"flag strategy over local expression", "[echo]\nexpression: \"5\"\n", []string{"--strategy", "linear"}, ""},
+		{"flag expression over local strategy", "[echo]\nstrategy: \"linear\"\n", []string{"-e", "7"}, "7"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			policy, err := loadParameters(parseFlags(t, test.args...), "echo", writeIni(t, test.ini))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if policy.Expression != test.expression || policy.Strategy.Name != "linear" {
+				t.Errorf("expected expression %q with the linear strategy, got %q with %q", test.expression, policy.Expression, policy.Strategy.Name)
+			}
+		})
+	}
+}
+
+func TestRuleSections(t *testing.T) {
+	ini := `
+[echo:quota]
+string_matches: "Quota exceeded"
+expression: "100"
+
+[other:ignored]
+exit_codes: 1
+
+[echo:conflict]
+string_matches: "already exists"
+action: succeed
+`
+	cmd := parseFlags(t, "--rule", "exit_codes=3 action=fail", "--rule", "name=flaky exit_codes=4")
+	policy, err := loadParameters(cmd, "echo", writeIni(t, ini))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rules from the command line come first, then the command's sections
+	var names []string
+	for _, rule := range policy.Rules {
+		names = append(names, rule.Name)
+	}
+	if len(names) != 4 || names[0] != "1" || names[1] != "flaky" || names[2] != "quota" || names[3] != "conflict" {
+		t.Fatalf("expected rules 1, flaky, quota and conflict, got %v", names)
+	}
+	if policy.Rules[3].Action != backoff.Succeed || policy.Rules[2].Expression != "100" {
+		t.Errorf("unexpected rules %v", policy.Rules)
+	}
+
+	_, err = loadParameters(parseFlags(t), "echo", writeIni(t, "[echo:bad]\naction: maybe\n"))
+	var valueErr *backoff.ValueError
+	if !errors.As(err, &valueErr) {
+		t.Errorf("expected a bad rule section to be a ValueError, got %v", err)
+	}
+}
+
+func TestSuccessOnExitCodesAfterFail(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		args   []string
+		script string
+		code   int
+	}{
+		{"fail on", []string{"-C", "2", "-o", "ERROR"}, "echo ERROR; exit 2", backoff.ForcedFailureExitCode},
+		{"fail unless", []string{"-C", "2", "-u", "OK"}, "echo FAILED; exit 2", backoff.ForcedFailureExitCode},
+		{"fail unless matched", []string{"-C", "2", "-u", "OK"}, "echo OK; exit 3", 0},
+		{"no fail match", []string{"-C", "2", "-o", "ERROR"}, "echo fine; exit 2", 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			policy, err := loadParameters(parseFlags(t, append([]string{"-e", "0"}, test.args...)...), "sh", writeIni(t, ""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := backoff.Run(context.Background(), policy, []string{"sh", "-c", test.script})
+			if result.ExitCode != test.code || len(result.Attempts) != 1 {
+				t.Errorf("expected exit code %d after 1 attempt, got %d after %d from %q", test.code, result.ExitCode, len(result.Attempts), result.Rule)
+			}
+		})
+	}
+}
+
+func TestLoadParametersErrors(t *testing.T) {
+	var iniErr *IniFileError
+	_, err := loadParameters(parseFlags(t), "echo", filepath.Join(t.TempDir(), "missing.ini"))
+	if !errors.As(err, &iniErr) {
+		t.Errorf("expected a missing INI file to be an IniFileError, got %v", err)
+	}
+
+	for _, test := range []struct {
+		name string
+		ini  string
+		args []string
+	}{
+		{"passthrough with retries", "", []string{"--passthrough-stdin", "-r", "3"}},
+		{"passthrough with retries from the INI file", "[echo]\nretries: 2\npassthrough_stdin: true\n", nil},
+		{"strategy", "", []string{"--strategy", "sometimes"}},
+		{"match window", "match_window: \"10 pages\"\n", nil},
+		{"retries", "retries: three\n", nil},
+		{"retry on all", "[echo]\nretry_on_all: yes please\n", nil},
+		{"attempt timeout", "attempt_timeout: 5m\n", nil},
+		{"kill grace period", "[echo]\nkill_grace_period: 2.5\n", nil},
+		{"duration", "[echo]\nduration: 1h\n", nil},
+		{"base", "strategy: \"exponential\"\nbase: two\n", nil},
+		{"cap", "[echo]\nstrategy: \"linear\"\ncap: 60s\n", nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := loadParameters(parseFlags(t, test.args...), "echo", writeIni(t, test.ini))
+			var valueErr *backoff.ValueError
+			if !errors.As(err, &valueErr) || errorExitCode(err) != backoff.InternalErrorExitCode {
+				t.Errorf("expected a ValueError giving exit code %d, got %v", backoff.InternalErrorExitCode, err)
+			}
+		})
+	}
+
+	var exitCodeErr *backoff.ExitCodeError
+	_, err = loadParameters(parseFlags(t), "echo", writeIni(t, "[echo]\nretry_on_exit_codes: \"1,two\"\n"))
+	if !errors.As(err, &exitCodeErr) {
+		t.Errorf("expected an ExitCodeError, got %v", err)
+	}
+
+	policy, err := loadParameters(parseFlags(t, "--passthrough-stdin", "-r", "0"), "echo", writeIni(t, ""))
+	if err != nil || !policy.PassthroughStdin {
+		t.Errorf("expected stdin to be passed through without retries, got %v", err)
+	}
+
+	// A value the command line overrides is never read
+	policy, err = loadParameters(parseFlags(t, "-r", "2", "-d", "30"), "echo", writeIni(t, "retries: three\n[echo]\nduration: 1h\n"))
+	if err != nil || policy.Retries != 2 || policy.Duration != 30*time.Second {
+		t.Errorf("expected the flags to override the INI file, got %d retries and %s: %v", policy.Retries, policy.Duration, err)
+	}
+}
 
-	ExponentialBackoff(command, "1", 4, 10, true, retryCodes, retryStrings, retryRegexps, "")
+func TestErrorExitCode(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"value", &backoff.ValueError{Setting: "strategy", Value: "sometimes"}, backoff.InternalErrorExitCode},
+		{"formula", &backoff.FormulaError{Expression: "2 **", Compile: true}, backoff.InternalErrorExitCode},
+		{"ini file", &IniFileError{File: "missing.ini", Err: os.ErrNotExist}, backoff.InternalErrorExitCode},
+		{"exit codes", &backoff.ExitCodeError{List: "1,two"}, backoff.InternalErrorExitCode},
+		{"wrapped", fmt.Errorf("rule %q: %w", "quota", &backoff.ValueError{Setting: "action"}), backoff.InternalErrorExitCode},
+		{"hook", &backoff.HookError{Hook: "Failure", Command: "false", ExitCode: 1}, 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if code := errorExitCode(test.err); code != test.code {
+				t.Errorf("expected exit code %d, got %d", test.code, code)
+			}
+		})
+	}
 }
@@ -6,19 +6,18 @@
 package cmd
 
 import (
-	"bytes"
-	"encoding/csv"
+	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"os"
-	"os/exec"
-	"regexp"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/Knetic/govaluate"
-	shellwords "github.com/mattn/go-shellwords"
+	"github.com/blackducksoftware/exponential-backoff-tool/backoff"
 	logging "github.com/op/go-logging"
 	"github.com/spf13/cobra"
 	ini "gopkg.in/ini.v1"
@@ -34,6 +33,11 @@ var _kill bool
 var _verbose bool
 var _version bool
 var _expression string
+var _strategy string
+var _base float64
+var _factor float64
+var _cap float64
+var _min float64
 var _retries int
 var _duration int
 var _iniFile string
@@ -41,18 +45,38 @@ var _printRetryOnFailure bool
 var _printVerboseRetryOnFailure bool
 var _retryOnAll bool
 var _retryOnExitCodes string
+var _retryOnSignals string
 var _retryOnStringMatches string
 var _retryOnRegexpMatches string
+var _retryOnJSONMatches string
+var _retryIf string
 var _successOnExitCodes string
 var _successOnStringMatches string
 var _successOnRegexpMatches string
+var _successOnJSONMatches string
+var _succeedIf string
 var _failOnStringMatches string
 var _failOnRegexpMatches string
+var _failOnJSONMatches string
+var _failIf string
 var _failUnlessStringMatches string
 var _failUnlessRegexpMatches string
 var _performOnFailure string
 var _performOnExit string
 var _metricsEnabled bool
+var _outputMode string
+var _attemptTimeout int
+var _retryOnTimeout bool
+var _killGracePeriod int
+var _durationStopsAttempt bool
+var _passthroughStdin bool
+var _prefixOutput bool
+var _seed int64
+var _retryAfterRegexp string
+var _retryAfterOverrides bool
+var _explain bool
+var _matchWindow string
+var _rules []string
 
 // The command definition
 var rootCmd = &cobra.Command{
@@ -76,6 +100,9 @@ backoff based off of either error messages, or exit codes.`,
 		}
 		if _kill {
 			s1 := rand.NewSource(time.Now().UnixNano())
+			if cmd.Flags().Changed("seed") {
+				s1 = rand.NewSource(_seed)
+			}
 			r1 := rand.New(s1)
 			if r1.Float64() > .875 {
 				fmt.Println("Sample Error: TLS Timeout")
@@ -105,41 +132,121 @@ backoff based off of either error messages, or exit codes.`,
 		if len(args) < 1 {
 			cmd.Help()
 			os.Stderr.WriteString("\nExponential Backoff Tool requires at least 1 argument!\n")
-			os.Exit(1)
+			os.Exit(backoff.InternalErrorExitCode)
+		}
+		command, err := convertArgs(args)
+		if err != nil {
+			exitOnError(err)
+		}
+		policy, err := loadParameters(cmd, command[0], _iniFile)
+		if err != nil {
+			exitOnError(err)
 		}
-		command := convertArgs(args)
-		expression, retries, duration, retryOnAll, ignoreExitCodes, ignoreStrings, ignoreRegexps, successOnExitCodes, successOnStrings, successOnRegexps, performOnFailure, failOnStrings, failOnRegexps, failUnlessStrings, failUnlessRegexps, printRetryOnFailure, printVerboseRetryOnFailure, metricsEnabled, performOnExit := loadParameters(cmd, command[0], _iniFile, _expression, _retries, _duration, _retryOnAll, _retryOnExitCodes, _retryOnStringMatches, _retryOnRegexpMatches, _successOnExitCodes, _successOnStringMatches, _successOnRegexpMatches, _performOnFailure, _failOnStringMatches, _failOnRegexpMatches, _failUnlessStringMatches, _failUnlessRegexpMatches, _printRetryOnFailure, _printVerboseRetryOnFailure, _metricsEnabled, _performOnExit)
-		code := ExponentialBackoff(command, expression, retries, duration, retryOnAll, ignoreExitCodes, ignoreStrings, ignoreRegexps, successOnExitCodes, successOnStrings, successOnRegexps, performOnFailure, failOnStrings, failOnRegexps, failUnlessStrings, failUnlessRegexps, printRetryOnFailure, printVerboseRetryOnFailure, metricsEnabled)
-		if performOnExit != "" {
-			catchFailure("Exit",performOnExit)
+		ctx, stop := forwardSignals()
+		result := backoff.Run(ctx, policy, command)
+		stop()
+		logSummary(result)
+		if result.Reason == backoff.ExpressionError {
+			log.Critical(result.Err)
+			os.Exit(result.ExitCode)
 		}
-		os.Exit(code)
+		var hookErr *backoff.HookError
+		if errors.As(result.Err, &hookErr) {
+			exitOnError(hookErr)
+		}
+		os.Exit(result.ExitCode)
 	},
 }
 
-func convertArgs(command []string) []string {
+// forwardSignals returns a context that is cancelled when eb receives
+// SIGINT, SIGTERM or SIGHUP. The signal is the context's cause, so the
+// retry loop stops and passes it on to the running command. A second
+// signal kills the command without waiting out the grace period.
+func forwardSignals() (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	ctx, kill := backoff.WithKill(ctx)
+	stopped := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Warning("Received ", sig, ". Stopping.")
+			cancel(&backoff.SignalError{Signal: sig.(syscall.Signal)})
+		case <-stopped:
+			return
+		}
+		select {
+		case sig := <-signals:
+			log.Warning("Received ", sig, " again. Killing the command.")
+			kill()
+		case <-stopped:
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel(nil)
+		close(stopped)
+	}
+}
+
+// exitOnError reports an error that stops eb from running the command to
+// completion, and exits
+func exitOnError(err error) {
+	log.Critical(err)
+	os.Exit(errorExitCode(err))
+}
+
+// errorExitCode gives settings that cannot be read or used the same exit
+// code as an expression that cannot be evaluated, so they are not mistaken
+// for the command failing
+func errorExitCode(err error) int {
+	var valueErr *backoff.ValueError
+	var formulaErr *backoff.FormulaError
+	var iniErr *IniFileError
+	var listErr *backoff.ListError
+	var exitCodeErr *backoff.ExitCodeError
+	var regexpErr *backoff.RegexpError
+	var commandErr *backoff.CommandError
+	switch {
+	case errors.As(err, &valueErr), errors.As(err, &formulaErr), errors.As(err, &iniErr),
+		errors.As(err, &listErr), errors.As(err, &exitCodeErr), errors.As(err, &regexpErr),
+		errors.As(err, &commandErr):
+		return backoff.InternalErrorExitCode
+	}
+	return 1
+}
+
+// logSummary prints the outcome of the retry loop when running verbosely
+func logSummary(result backoff.Result) {
+	log.Info("-------- Summary --------")
+	log.Info("Outcome                  : ", result.Reason)
+	log.Info("Exit Code                : ", result.ExitCode)
+	log.Info("Attempts                 : ", len(result.Attempts))
+	log.Info("Elapsed                  : ", result.Elapsed())
+	if result.Rule != "" {
+		log.Info("Matched                  : ", result.Rule)
+	}
+	for _, attempt := range result.Attempts {
+		if attempt.Signal != 0 {
+			log.Info("Attempt", attempt.Number, "was killed by", backoff.SignalName(attempt.Signal), "after", attempt.Elapsed, "then slept", attempt.Sleep)
+			continue
+		}
+		log.Info("Attempt", attempt.Number, "exited with", attempt.ExitCode, "after", attempt.Elapsed, "then slept", attempt.Sleep)
+	}
+	log.Info("-------------------------")
+}
+
+func convertArgs(command []string) ([]string, error) {
 	log.Debug("Command Parts")
 	for _, element := range command {
 		log.Debug(element)
 	}
 
 	if len(command) == 1 {
-		commandString := command[0]
-		log.Debug("Parsing:", commandString)
-		var err error
-		command, err = shellwords.Parse(commandString)
-		if err != nil {
-			log.Error("Unable to parse command input:", commandString)
-			log.Critical(err)
-			os.Exit(1)
-		}
-
-		log.Debug("Command string parsed as:\n")
-		for _, field := range command {
-			log.Debug(field)
-		}
+		return backoff.ParseCommand(command[0])
 	}
-	return command
+	return command, nil
 }
 
 func configureLogging(verbose bool, debug bool) {
@@ -159,6 +266,7 @@ func configureLogging(verbose bool, debug bool) {
 		backend1Leveled.SetLevel(logging.DEBUG, "")
 	}
 	logging.SetBackend(backend1Leveled)
+	backoff.SetLogBackend(backend1Leveled)
 	log = logging.MustGetLogger("root")
 }
 
@@ -174,90 +282,126 @@ func getStringParameter(cmd *cobra.Command, cfg *ini.File, command string, key s
 	return currentValue
 }
 
-func getIntParameter(cmd *cobra.Command, cfg *ini.File, command string, key string, currentValue int, flag string) int {
-	if cfg.Section(command).HasKey(key) {
-		value, _ := cfg.Section(command).Key(key).Int()
-		if !cmd.Flags().Changed(flag) {
-			return value
+func getIntParameter(cmd *cobra.Command, cfg *ini.File, command string, key string, currentValue int, flag string) (int, error) {
+	if cfg.Section(command).HasKey(key) && !cmd.Flags().Changed(flag) {
+		value, err := cfg.Section(command).Key(key).Int()
+		if err != nil {
+			return currentValue, &backoff.ValueError{Setting: key, Value: cfg.Section(command).Key(key).String(), Expected: "a whole number"}
 		}
+		return value, nil
 	}
-	return currentValue
+	return currentValue, nil
 }
 
-func getBoolParameter(cmd *cobra.Command, cfg *ini.File, command string, key string, currentValue bool, flag string) bool {
-	if cfg.Section(command).HasKey(key) {
-		value, _ := cfg.Section(command).Key(key).Bool()
-		if !cmd.Flags().Changed(flag) {
-			return value
+func getFloatParameter(cmd *cobra.Command, cfg *ini.File, command string, key string, currentValue float64, flag string) (float64, error) {
+	if cfg.Section(command).HasKey(key) && !cmd.Flags().Changed(flag) {
+		value, err := cfg.Section(command).Key(key).Float64()
+		if err != nil {
+			return currentValue, &backoff.ValueError{Setting: key, Value: cfg.Section(command).Key(key).String(), Expected: "a number"}
 		}
+		return value, nil
 	}
-	return currentValue
+	return currentValue, nil
 }
 
-func csvStringToIntArray(s string) []int {
-	log.Debug("Converting to int array:", s)
-	var retint []int
-	if s != "" {
-		r := csv.NewReader(strings.NewReader(s))
-		intStr, err := r.Read()
-		if err != nil {
-			log.Critical(err)
-			os.Exit(1)
-		}
-
-		log.Debug("Int fields found:\n")
-		for _, field := range intStr {
-			code, err := strconv.Atoi(field)
-			if err != nil {
-				log.Critical(err)
-				os.Exit(1)
-			}
-			retint = append(retint, code)
-			log.Debug(code)
-		}
+// parameterLevel is how specific the place a parameter was set is: 3 for
+// the command line, 2 for the local section, 1 for the global section and
+// 0 when it was not set at all
+func parameterLevel(cmd *cobra.Command, cfg *ini.File, command string, key string, flag string) int {
+	switch {
+	case cmd.Flags().Changed(flag):
+		return 3
+	case cfg == nil:
+		return 0
+	case cfg.Section(command).HasKey(key):
+		return 2
+	case cfg.Section("").HasKey(key):
+		return 1
 	}
-	return retint
+	return 0
 }
 
-func csvStringToStringArray(s string) []string {
-	log.Debug("Converting to string array:", s)
-	var retstring []string
-	if s != "" {
-		r := csv.NewReader(strings.NewReader(s))
-		stringsStr, err := r.Read()
+func getBoolParameter(cmd *cobra.Command, cfg *ini.File, command string, key string, currentValue bool, flag string) (bool, error) {
+	if cfg.Section(command).HasKey(key) && !cmd.Flags().Changed(flag) {
+		value, err := cfg.Section(command).Key(key).Bool()
 		if err != nil {
-			log.Critical(err)
-			os.Exit(1)
-		}
-		log.Debug("Strings Found:\n")
-		for _, field := range stringsStr {
-			log.Debug(field)
-			retstring = append(retstring, field)
+			return currentValue, &backoff.ValueError{Setting: key, Value: cfg.Section(command).Key(key).String(), Expected: "true or false"}
 		}
+		return value, nil
 	}
-	return retstring
+	return currentValue, nil
 }
-func csvStringToRegexpArray(s string) []*regexp.Regexp {
-	log.Debug("Converting to regexp array:", s)
-	var retRegexps []*regexp.Regexp
-	if s != "" {
-		r := csv.NewReader(strings.NewReader(s))
-		regexpStr, err := r.Read()
-		if err != nil {
-			log.Critical(err)
-			os.Exit(1)
-		}
-		log.Debug("Regexp Strings Found:\n")
-		for _, field := range regexpStr {
-			log.Debug(field)
-			retRegexps = append(retRegexps, regexp.MustCompile(field))
-		}
+
+// stdinIsRedirected reports whether eb was given input through a pipe or
+// file. Input typed at a terminal is never replayed, as it is meant for a
+// person at the terminal.
+func stdinIsRedirected() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return false
 	}
-	return retRegexps
+	return info.Mode()&os.ModeCharDevice == 0
 }
 
-func loadParameters(cmd *cobra.Command, command string, iniFile string, expression string, retries int, duration int, retryOnAll bool, retryOnExitCodes string, retryOnStringMatches string, retryOnRegexpMatches string, successOnExitCodes string, successOnStringMatches string, successOnRegexpMatches string, performOnFailure string, failOnStringMatches string, failOnRegexpMatches string, failUnlessStringMatches string, failUnlessRegexpMatches string, printRetryOnFailure bool, printVerboseRetryOnFailure bool, metricsEnabled bool, performOnExit string) (string, int, int, bool, []int, []string, []*regexp.Regexp, []int, []string, []*regexp.Regexp, string, []string, []*regexp.Regexp, []string, []*regexp.Regexp, bool, bool, bool, string) {
-	// Configure the defaxwult location of the INI file
+// IniFileError is returned when an INI file passed in with --ini-file
+// cannot be read
+type IniFileError struct {
+	File string
+	Err  error
+}
+
+func (e *IniFileError) Error() string {
+	return fmt.Sprintf("unable to read INI file %s: %v", e.File, e.Err)
+}
+
+func (e *IniFileError) Unwrap() error { return e.Err }
+
+func loadParameters(cmd *cobra.Command, command string, iniFile string) (backoff.Policy, error) {
+	// Start from whatever was passed in on the command line
+	expression := _expression
+	strategy := _strategy
+	base := _base
+	factor := _factor
+	backoffCap := _cap
+	backoffMin := _min
+	retries := _retries
+	duration := _duration
+	retryOnAll := _retryOnAll
+	retryOnExitCodes := _retryOnExitCodes
+	retryOnSignals := _retryOnSignals
+	retryOnStringMatches := _retryOnStringMatches
+	retryOnRegexpMatches := _retryOnRegexpMatches
+	retryOnJSONMatches := _retryOnJSONMatches
+	retryIf := _retryIf
+	successOnExitCodes := _successOnExitCodes
+	successOnStringMatches := _successOnStringMatches
+	successOnRegexpMatches := _successOnRegexpMatches
+	successOnJSONMatches := _successOnJSONMatches
+	succeedIf := _succeedIf
+	performOnFailure := _performOnFailure
+	performOnExit := _performOnExit
+	failOnStringMatches := _failOnStringMatches
+	failOnRegexpMatches := _failOnRegexpMatches
+	failOnJSONMatches := _failOnJSONMatches
+	failIf := _failIf
+	failUnlessStringMatches := _failUnlessStringMatches
+	failUnlessRegexpMatches := _failUnlessRegexpMatches
+	printRetryOnFailure := _printRetryOnFailure
+	printVerboseRetryOnFailure := _printVerboseRetryOnFailure
+	metricsEnabled := _metricsEnabled
+	outputMode := _outputMode
+	prefixOutput := _prefixOutput
+	attemptTimeout := _attemptTimeout
+	retryOnTimeout := _retryOnTimeout
+	killGracePeriod := _killGracePeriod
+	durationStopsAttempt := _durationStopsAttempt
+	passthroughStdin := _passthroughStdin
+	retryAfterRegexp := _retryAfterRegexp
+	retryAfterOverrides := _retryAfterOverrides
+	explain := _explain
+	matchWindow := _matchWindow
+
+	// Configure the default location of the INI file
 	loadIniFile := iniFile
 	if iniFile == "" {
 		home, err := os.UserHomeDir()
@@ -271,12 +415,11 @@ func loadParameters(cmd *cobra.Command, command string, iniFile string, expressi
 		log.Info("No INI file specified. Using " + loadIniFile + " if it exists.")
 	}
 
-	// Read the INI file - Local values override global valuesds
+	// Read the INI file - Local values override global values
 	cfg, err := ini.Load(loadIniFile)
 	if err != nil {
 		if iniFile != "" {
-			log.Critical("Fail to read file: ", err)
-			os.Exit(1)
+			return backoff.Policy{}, &IniFileError{File: iniFile, Err: err}
 		}
 		log.Warning("Fail to read file: ", err)
 	} else {
@@ -287,479 +430,305 @@ func loadParameters(cmd *cobra.Command, command string, iniFile string, expressi
 
 		// If  not defined there, check the global section
 		expression = getStringParameter(cmd, cfg, "", "expression", expression, "expression")
-		retries = getIntParameter(cmd, cfg, "", "retries", retries, "retries")
-		duration = getIntParameter(cmd, cfg, "", "duration", duration, "duration")
-		metricsEnabled = getBoolParameter(cmd, cfg, "", "metrics_enabled", metricsEnabled, "metrics-enabled")
+		strategy = getStringParameter(cmd, cfg, "", "strategy", strategy, "strategy")
+		if base, err = getFloatParameter(cmd, cfg, "", "base", base, "base"); err != nil {
+			return backoff.Policy{}, err
+		}
+		if factor, err = getFloatParameter(cmd, cfg, "", "factor", factor, "factor"); err != nil {
+			return backoff.Policy{}, err
+		}
+		if backoffCap, err = getFloatParameter(cmd, cfg, "", "cap", backoffCap, "cap"); err != nil {
+			return backoff.Policy{}, err
+		}
+		if backoffMin, err = getFloatParameter(cmd, cfg, "", "min", backoffMin, "min"); err != nil {
+			return backoff.Policy{}, err
+		}
+		if retries, err = getIntParameter(cmd, cfg, "", "retries", retries, "retries"); err != nil {
+			return backoff.Policy{}, err
+		}
+		if duration, err = getIntParameter(cmd, cfg, "", "duration", duration, "duration"); err != nil {
+			return backoff.Policy{}, err
+		}
+		if durationStopsAttempt, err = getBoolParameter(cmd, cfg, "", "duration_stops_attempt", durationStopsAttempt, "duration-stops-attempt"); err != nil {
+			return backoff.Policy{}, err
+		}
+		if metricsEnabled, err = getBoolParameter(cmd, cfg, "", "metrics_enabled", metricsEnabled, "enable-metrics"); err != nil {
+			return backoff.Policy{}, err
+		}
 		performOnExit = getStringParameter(cmd, cfg, "", "perform_on_exit", performOnExit, "perform-on-exit")
+		outputMode = getStringParameter(cmd, cfg, "", "output_mode", outputMode, "output-mode")
+		if prefixOutput, err = getBoolParameter(cmd, cfg, "", "prefix_output", prefixOutput, "prefix-output"); err != nil {
+			return backoff.Policy{}, err
+		}
+		if attemptTimeout, err = getIntParameter(cmd, cfg, "", "attempt_timeout", attemptTimeout, "attempt-timeout"); err != nil {
+			return backoff.Policy{}, err
+		}
+		if retryOnTimeout, err = getBoolParameter(cmd, cfg, "", "retry_on_timeout", retryOnTimeout, "retry-on-timeout"); err != nil {
+			return backoff.Policy{}, err
+		}
+		if killGracePeriod, err = getIntParameter(cmd, cfg, "", "kill_grace_period", killGracePeriod, "kill-grace-period"); err != nil {
+			return backoff.Policy{}, err
+		}
+		retryAfterRegexp = getStringParameter(cmd, cfg, "", "retry_after_regexp", retryAfterRegexp, "retry-after-regexp")
+		if retryAfterOverrides, err = getBoolParameter(cmd, cfg, "", "retry_after_overrides", retryAfterOverrides, "retry-after-overrides"); err != nil {
+			return backoff.Policy{}, err
+		}
+		if explain, err = getBoolParameter(cmd, cfg, "", "explain", explain, "explain"); err != nil {
+			return backoff.Policy{}, err
+		}
+		matchWindow = getStringParameter(cmd, cfg, "", "match_window", matchWindow, "match-window")
 
 		log.Debug("After Loading Global INI Settings:")
 		log.Debug("Expression: ", expression)
+		log.Debug("Strategy: ", strategy)
 		log.Debug("Retries: ", retries)
 		log.Debug("Duration: ", duration)
-		log.Debug("Retry On All: ", retryOnAll)
-		log.Debug("Retry On Exit Codes: ", retryOnExitCodes)
-		log.Debug("Retry On String Matches: ", retryOnStringMatches)
-		log.Debug("Retry On Regexp Matches: ", retryOnRegexpMatches)
-		log.Debug("Success On Exit Codes: ", successOnExitCodes)
-		log.Debug("Success On String Matches: ", successOnStringMatches)
-		log.Debug("Success On Regexp Matches: ", successOnRegexpMatches)
-		log.Debug("Perform On Failure: ", performOnFailure)
-		log.Debug("Perform On Exit: ", performOnExit)
-		log.Debug("Fail On String Matches: ", failOnStringMatches)
-		log.Debug("Fail On Regexp Matches: ", failOnRegexpMatches)
-		log.Debug("Fail Unless String Matches: ", failUnlessStringMatches)
-		log.Debug("Fail Unless Regexp Matches: ", failUnlessRegexpMatches)
-		log.Debug("Print Retry On Failure: ", printRetryOnFailure)
-		log.Debug("Print Verbose Retry On Failure: ", printVerboseRetryOnFailure)
+		log.Debug("Duration Stops Attempt: ", durationStopsAttempt)
 		log.Debug("Metrics Enabled: ", metricsEnabled)
+		log.Debug("Perform On Exit: ", performOnExit)
+		log.Debug("Output Mode: ", outputMode)
+		log.Debug("Prefix Output: ", prefixOutput)
+		log.Debug("Attempt Timeout: ", attemptTimeout)
+		log.Debug("Retry On Timeout: ", retryOnTimeout)
+		log.Debug("Kill Grace Period: ", killGracePeriod)
+		log.Debug("Retry After Regexp: ", retryAfterRegexp)
+		log.Debug("Retry After Overrides: ", retryAfterOverrides)
+		log.Debug("Explain: ", explain)
+		log.Debug("Match Window: ", matchWindow)
 
 		// If anything is defined in the local section, override
-		// cmd.Flags().IsSet()
 		retryOnExitCodes = getStringParameter(cmd, cfg, command, "retry_on_exit_codes", retryOnExitCodes, "retry-on-exit-codes")
+		retryOnSignals = getStringParameter(cmd, cfg, command, "retry_on_signals", retryOnSignals, "retry-on-signals")
 		retryOnStringMatches = getStringParameter(cmd, cfg, command, "retry_on_string_matches", retryOnStringMatches, "retry-on-string-matches")
 		retryOnRegexpMatches = getStringParameter(cmd, cfg, command, "retry_on_regexp_matches", retryOnRegexpMatches, "retry-on-regexp-matches")
+		retryOnJSONMatches = getStringParameter(cmd, cfg, command, "retry_on_json_matches", retryOnJSONMatches, "retry-on-json-matches")
+		retryIf = getStringParameter(cmd, cfg, command, "retry_if", retryIf, "retry-if")
 		successOnExitCodes = getStringParameter(cmd, cfg, command, "success_on_exit_codes", successOnExitCodes, "success-on-exit-codes")
 		successOnStringMatches = getStringParameter(cmd, cfg, command, "success_on_string_matches", successOnStringMatches, "success-on-string-matches")
 		successOnRegexpMatches = getStringParameter(cmd, cfg, command, "success_on_regexp_matches", successOnRegexpMatches, "success-on-regexp-matches")
+		successOnJSONMatches = getStringParameter(cmd, cfg, command, "success_on_json_matches", successOnJSONMatches, "success-on-json-matches")
+		succeedIf = getStringParameter(cmd, cfg, command, "succeed_if", succeedIf, "succeed-if")
 		performOnFailure = getStringParameter(cmd, cfg, command, "perform_on_failure", performOnFailure, "perform-on-failure")
 		performOnExit = getStringParameter(cmd, cfg, command, "perform_on_exit", performOnExit, "perform-on-exit")
 		failOnStringMatches = getStringParameter(cmd, cfg, command, "fail_on_string_matches", failOnStringMatches, "fail-on-string-matches")
 		failOnRegexpMatches = getStringParameter(cmd, cfg, command, "fail_on_regexp_matches", failOnRegexpMatches, "fail-on-regexp-matches")
+		failOnJSONMatches = getStringParameter(cmd, cfg, command, "fail_on_json_matches", failOnJSONMatches, "fail-on-json-matches")
+		failIf = getStringParameter(cmd, cfg, command, "fail_if", failIf, "fail-if")
 		failUnlessStringMatches = getStringParameter(cmd, cfg, command, "fail_unless_string_matches", failUnlessStringMatches, "fail-unless-string-matches")
 		failUnlessRegexpMatches = getStringParameter(cmd, cfg, command, "fail_unless_regexp_matches", failUnlessRegexpMatches, "fail-unless-regexp-matches")
-		retryOnAll = getBoolParameter(cmd, cfg, command, "retry_on_all", retryOnAll, "retry-on-all")
+		if retryOnAll, err = getBoolParameter(cmd, cfg, command, "retry_on_all", retryOnAll, "retry-on-all"); err != nil {
+			return backoff.Policy{}, err
+		}
 		expression = getStringParameter(cmd, cfg, command, "expression", expression, "expression")
-		retries = getIntParameter(cmd, cfg, command, "retries", retries, "retries")
-		duration = getIntParameter(cmd, cfg, command, "duration", duration, "duration")
-		printRetryOnFailure = getBoolParameter(cmd, cfg, command, "print_retry_on_failure", printRetryOnFailure, "print-retry-on-failure")
-		printVerboseRetryOnFailure = getBoolParameter(cmd, cfg, command, "print_verbose_retry_on_failure", printVerboseRetryOnFailure, "print-verbose-retry-on-failure")
-		metricsEnabled = getBoolParameter(cmd, cfg, command, "metrics_enabled", metricsEnabled, "metrics-enabled")
-
-
-		log.Debug("After Loading Local INI Settings:")
-		log.Debug("Expression: ", expression)
-		log.Debug("Retries: ", retries)
-		log.Debug("Duration: ", duration)
-		log.Debug("Retry On All: ", retryOnAll)
-		log.Debug("Retry On Exit Codes: ", retryOnExitCodes)
-		log.Debug("Retry On String Matches: ", retryOnStringMatches)
-		log.Debug("Retry On Regexp Matches: ", retryOnRegexpMatches)
-		log.Debug("Success On Exit Codes: ", successOnExitCodes)
-		log.Debug("Success On String Matches: ", successOnStringMatches)
-		log.Debug("Success On Regexp Matches: ", successOnRegexpMatches)
-		log.Debug("Perform On Failure: ", performOnFailure)
-		log.Debug("Perform On Exit: ", performOnExit)
-		log.Debug("Fail On String Matches: ", failOnStringMatches)
-		log.Debug("Fail On Regexp Matches: ", failOnRegexpMatches)
-		log.Debug("Fail Unless String Matches: ", failUnlessStringMatches)
-		log.Debug("Fail Unless Regexp Matches: ", failUnlessRegexpMatches)
-		log.Debug("Print Retry On Failure: ", printRetryOnFailure)
-		log.Debug("Print Verbose Retry On Failure: ", printVerboseRetryOnFailure)
-		log.Debug("Metrics Enabled: ", metricsEnabled)
-
-	}
-
-	// If after checking everywhere, the values are still -1, set them to their defaults
-	/*
-		if expression == "" {
-			expression = _realExpressionDefault
+		strategy = getStringParameter(cmd, cfg, command, "strategy", strategy, "strategy")
+		if base, err = getFloatParameter(cmd, cfg, command, "base", base, "base"); err != nil {
+			return backoff.Policy{}, err
 		}
-
-		if retries == -1 && duration == -1 {
-			retries = _realRetryDefault
-			duration = _realMaxDurationDefault
-		}d
-	*/
-
-	// Treat the retry codes list as a row from a CSV file so we don't need to do intelligent parsing
-	// var ignoreExitCodes []int
-	log.Debug("Converting retyOnExitCodes...")
-	ignoreExitCodes := csvStringToIntArray(retryOnExitCodes)
-	log.Debug("Converting successOnExitCodes...")
-	successExitCodes := csvStringToIntArray(successOnExitCodes)
-
-	// Treat the retry strings list as a row from a CSV file so we don't need to do intelligent parsing
-	// var ignoreStrings []string
-	log.Debug("Converting retryOnStringMatches...")
-	ignoreStrings := csvStringToStringArray(retryOnStringMatches)
-	log.Debug("Converting successOnStringMatches...")
-	successStrings := csvStringToStringArray(successOnStringMatches)
-
-	// Treat the retry strings list as a row from a CSV file so we don't need to do intelligent parsing
-	log.Debug("Converting retryOnRegexpMatches...")
-	ignoreRegexps := csvStringToRegexpArray(retryOnRegexpMatches)
-	log.Debug("Converting successOnRegexpMatches...")
-	successRegexps := csvStringToRegexpArray(successOnRegexpMatches)
-
-	log.Debug("Converting failOnStringMatches...")
-	failOnStrings := csvStringToStringArray(failOnStringMatches)
-	log.Debug("Converting failOnRegexpMatches...")
-	failOnRegexps := csvStringToRegexpArray(failOnRegexpMatches)
-
-	log.Debug("Converting failUnlessStringMatches...")
-	failUnlessStrings := csvStringToStringArray(failUnlessStringMatches)
-	log.Debug("Converting failUnlessRegexpMatches...")
-	failUnlessRegexps := csvStringToRegexpArray(failUnlessRegexpMatches)
-
-	log.Info("Fail On String Matches: ", failUnlessStringMatches)
-	log.Info("Fail On String Matches: ", failUnlessStrings)
-
-	return expression, retries, duration, retryOnAll, ignoreExitCodes, ignoreStrings, ignoreRegexps, successExitCodes, successStrings, successRegexps, performOnFailure, failOnStrings, failOnRegexps, failUnlessStrings, failUnlessRegexps, printRetryOnFailure, printVerboseRetryOnFailure, metricsEnabled, performOnExit
-}
-
-// ExponentialBackoff this is a separate function because perhaps somebody wants to run this
-// without calling the command line in their golang code
-func ExponentialBackoff(command []string, expression string, retries int, duration int, retryOnAll bool, ignoreExitCodes []int, ignoreStrings []string, ignoreRegexps []*regexp.Regexp, successExitCodes []int, successStrings []string, successRegexps []*regexp.Regexp, performOnFailure string, failOnStrings []string, failOnRegexps []*regexp.Regexp, failUnlessStrings []string, failUnlessRegexps []*regexp.Regexp, printRetryOnFailure bool, printVerboseRetryOnFailure bool, metricsEnabled bool) int {
-
-	log.Info("-------- Settings -------")
-	log.Info("Expression               : ", expression)
-	log.Info("Retries                  : ", retries)
-	log.Info("Duration                 : ", duration)
-	log.Info("Retry On All             : ", retryOnAll)
-	log.Info("Retry On Exit Codes      : ", ignoreExitCodes)
-	log.Info("Retry On String Matches  : ", ignoreStrings)
-	log.Info("Retry On Regexp Matches  : ", ignoreRegexps)
-	log.Info("Success On Exit Codes    : ", successExitCodes)
-	log.Info("Success On String Matches: ", successStrings)
-	log.Info("Success On Regexp Matches: ", successRegexps)
-	log.Info("Perform On Failure       : ", performOnFailure)
-	log.Info("Fail On String Matches: ", failOnStrings)
-	log.Info("Fail On Regexp Matches: ", failOnRegexps)
-	log.Info("Fail Unless String Matches: ", failUnlessStrings)
-	log.Info("Fail Unless Regexp Matches: ", failUnlessRegexps)
-	log.Info("Print Retry On Failure: ", printRetryOnFailure)
-	log.Info("Print Verbose Retry On Failure: ", printVerboseRetryOnFailure)
-	log.Info("Metrics Enabled: ", metricsEnabled)
-	log.Info("Command to Run           : ", command)
-	log.Info("-------------------------")
-
-	// we are going to change the global var to false if
-	// metric logging fails. So we need to reset it to whatever
-	// was loaded from the config
-	_metricsEnabled = metricsEnabled
-
-	xIncrement := 0
-	start := time.Now()
-	for {
-		metricStart := time.Now()
-
-		log.Debug("Running:", command[0])
-		log.Debug("Params:", command[1:len(command)])
-		cmd := exec.Command(command[0], command[1:len(command)]...)
-		var out bytes.Buffer
-		var stderr bytes.Buffer
-		cmd.Stdout = &out
-		cmd.Stderr = &stderr
-		cmd.Run()
-		exitCode := cmd.ProcessState.ExitCode()
-		log.Debug("Command exitted with ", exitCode)
-		needToExit := true
-
-		metricEnd := time.Now()
-		metricElapsed := metricEnd.Sub(metricStart)
-
-		logMetric(metricStart.String(),metricEnd.String(),fmt.Sprintf("%f",metricElapsed.Seconds()),command[0],strings.Join(command[1:len(command)]," "),strconv.Itoa(exitCode),out.String(),stderr.String())
-
-		// Automatic failure if certain string is matched
-		for i := range failOnStrings {
-			if strings.Contains(out.String(), failOnStrings[i]) {
-				log.Debug("Output stream contained: ", failOnStrings[i], ". Converting exit code to -1.")
-				exitCode = -1
-			}
-			if strings.Contains(stderr.String(), failOnStrings[i]) {
-				log.Debug("Error stream contained: ", failOnStrings[i], ". Converting exit code to -1.")
-				exitCode = -1
-			}
+		if factor, err = getFloatParameter(cmd, cfg, command, "factor", factor, "factor"); err != nil {
+			return backoff.Policy{}, err
 		}
-
-		// Automatic failure if certain regexp is matched
-		for i := range failOnRegexps {
-			if failOnRegexps[i].MatchString(out.String()) {
-				log.Debug("Output stream contained regexp: ", failOnRegexps[i], ". Converting exit code to -1.")
-				exitCode = -1
-			}
-			if failOnRegexps[i].MatchString(stderr.String()) {
-				log.Debug("Error stream contained regexp: ", failOnRegexps[i], ". Converting exit code to -1.")
-				exitCode = -1
-			}
+		if backoffCap, err = getFloatParameter(cmd, cfg, command, "cap", backoffCap, "cap"); err != nil {
+			return backoff.Policy{}, err
 		}
-
-		if len(failUnlessStrings) > 0 {
-			exitCode = -1
-			for i := range failUnlessStrings {
-				if strings.Contains(out.String(), failUnlessStrings[i]) {
-					log.Debug("Output stream contained: ", failUnlessStrings[i], ". Converting exit code to 0.")
-					needToExit = true
-					exitCode = 0
-				}
-				if strings.Contains(stderr.String(), failUnlessStrings[i]) {
-					log.Debug("Error stream contained: ", failUnlessStrings[i], ". Converting exit code to 0.")
-					needToExit = true
-					exitCode = 0
-				}
-			}
+		if backoffMin, err = getFloatParameter(cmd, cfg, command, "min", backoffMin, "min"); err != nil {
+			return backoff.Policy{}, err
 		}
-		if len(failUnlessRegexps) > 0 {
-			exitCode = -1
-			for i := range failUnlessRegexps {
-				if failUnlessRegexps[i].MatchString(out.String()) {
-					log.Debug("Output stream contained regexp: ", failUnlessRegexps[i], ". Converting exit code to 0.")
-					needToExit = true
-					exitCode = 0
-				}
-				if failUnlessRegexps[i].MatchString(stderr.String()) {
-					log.Debug("Error stream contained regexp: ", failUnlessRegexps[i], ". Converting exit code to 0.")
-					needToExit = true
-					exitCode = 0
-				}
-			}
+		if retries, err = getIntParameter(cmd, cfg, command, "retries", retries, "retries"); err != nil {
+			return backoff.Policy{}, err
 		}
-
-		for i := range successExitCodes {
-			if exitCode != 0 && exitCode == successExitCodes[i] {
-				log.Debug("Program exited with code: ", exitCode, ". Converting to exit code to 0.")
-				exitCode = 0
-			}
+		if duration, err = getIntParameter(cmd, cfg, command, "duration", duration, "duration"); err != nil {
+			return backoff.Policy{}, err
 		}
-
-		// Do not exit if output / stderr from the command contained a string in our retryOnMatchedStrings list
-		for i := range successStrings {
-			if exitCode != 0 && strings.Contains(out.String(), successStrings[i]) {
-				log.Debug("Output stream contained: ", successStrings[i], ". Converting exit code to 0.")
-				exitCode = 0
-			}
-			if exitCode != 0 && strings.Contains(stderr.String(), successStrings[i]) {
-				log.Debug("Error stream contained: ", successStrings[i], ". Converting exit code to 0.")
-				exitCode = 0
-			}
+		if durationStopsAttempt, err = getBoolParameter(cmd, cfg, command, "duration_stops_attempt", durationStopsAttempt, "duration-stops-attempt"); err != nil {
+			return backoff.Policy{}, err
 		}
-
-		// Do not exit if output / stderr from the command contained a string in our retryOnMatchedStrings list
-		for i := range successRegexps {
-			if exitCode != 0 && successRegexps[i].MatchString(out.String()) {
-				log.Debug("Output stream contained regexp: ", successRegexps[i], ". Converting exit code to 0.")
-				exitCode = 0
-			}
-			if exitCode != 0 && successRegexps[i].MatchString(stderr.String()) {
-				log.Debug("Error stream contained regexp: ", successRegexps[i], ". Converting exit code to 0.")
-				exitCode = 0
-			}
+		if printRetryOnFailure, err = getBoolParameter(cmd, cfg, command, "print_retry_on_failure", printRetryOnFailure, "print-retry-on-failure"); err != nil {
+			return backoff.Policy{}, err
 		}
-
-		// Do not exit if the exit code matched our input retryOnExitCodes
-		if exitCode != 0 {
-			if needToExit && retryOnAll {
-				log.Debug("Program exited with code: ", exitCode, ". Restarting on all non-zero exit codes.")
-				needToExit = false
-			}
-
-			for i := range ignoreExitCodes {
-				if needToExit && exitCode == ignoreExitCodes[i] {
-					log.Debug("Program exited with code: ", exitCode, ". Restarting.")
-					needToExit = false
-				}
-			}
-
-			// Do not exit if output / stderr from the command contained a string in our retryOnMatchedStrings list
-			for i := range ignoreStrings {
-				if needToExit && strings.Contains(out.String(), ignoreStrings[i]) {
-					log.Debug("Output stream contained: ", ignoreStrings[i], ". Restarting.")
-					needToExit = false
-				}
-				if needToExit && strings.Contains(stderr.String(), ignoreStrings[i]) {
-					log.Debug("Error stream contained: ", ignoreStrings[i], ". Restarting.")
-					needToExit = false
-				}
-			}
-
-			// Do not exit if output / stderr from the command contained a string in our retryOnMatchedStrings list
-			for i := range ignoreRegexps {
-				if needToExit && ignoreRegexps[i].MatchString(out.String()) {
-					log.Debug("Output stream contained regexp: ", ignoreRegexps[i], ". Restarting.")
-					needToExit = false
-				}
-				if needToExit && ignoreRegexps[i].MatchString(stderr.String()) {
-					log.Debug("Error stream contained regexp: ", ignoreRegexps[i], ". Restarting.")
-					needToExit = false
-				}
-			}
+		if printVerboseRetryOnFailure, err = getBoolParameter(cmd, cfg, command, "print_verbose_retry_on_failure", printVerboseRetryOnFailure, "print-verbose-retry-on-failure"); err != nil {
+			return backoff.Policy{}, err
 		}
-
-		if needToExit {
-			
-			log.Debug("Exiting with ", exitCode)
-			os.Stderr.WriteString(stderr.String())
-			fmt.Print(out.String())
-			return exitCode
+		if metricsEnabled, err = getBoolParameter(cmd, cfg, command, "metrics_enabled", metricsEnabled, "enable-metrics"); err != nil {
+			return backoff.Policy{}, err
 		}
-
-		log.Info(stderr.String())
-		log.Info(out.String())
-
-		xIncrement++
-		t := time.Now()
-		elapsed := t.Sub(start)
-		log.Debug("Elapsed Time:", elapsed)
-
-		if xIncrement > retries && retries != -1 {
-			log.Warning("Failed to complete command due to retries exhausted:", command)
-			log.Warning("Exitting with error code:", exitCode)
-			os.Stderr.WriteString(stderr.String())
-			fmt.Print(out.String())
-			return exitCode
+		outputMode = getStringParameter(cmd, cfg, command, "output_mode", outputMode, "output-mode")
+		if prefixOutput, err = getBoolParameter(cmd, cfg, command, "prefix_output", prefixOutput, "prefix-output"); err != nil {
+			return backoff.Policy{}, err
 		}
-
-		log.Debug("Time check:", elapsed, ">=", time.Duration(duration)*time.Second, "?")
-		if elapsed >= time.Duration(duration)*time.Second && duration != -1 {
-			log.Warning("Failed to complete command due to maximum runtime exhausted:", command)
-			log.Warning("Exitting with error code:", exitCode)
-			os.Stderr.WriteString(stderr.String())
-			fmt.Print(out.String())
-			return exitCode
+		if passthroughStdin, err = getBoolParameter(cmd, cfg, command, "passthrough_stdin", passthroughStdin, "passthrough-stdin"); err != nil {
+			return backoff.Policy{}, err
 		}
-
-		expression, err := govaluate.NewEvaluableExpression(expression)
-		if err != nil {
-			log.Error("Formula cannot be evaluated!")
-			return 2
+		if attemptTimeout, err = getIntParameter(cmd, cfg, command, "attempt_timeout", attemptTimeout, "attempt-timeout"); err != nil {
+			return backoff.Policy{}, err
 		}
-
-		s1 := rand.NewSource(time.Now().UnixNano())
-		r1 := rand.New(s1)
-		parameters := make(map[string]interface{}, 8)
-		parameters["x"] = xIncrement - 1
-		parameters["i"] = xIncrement
-		parameters["r"] = r1.Float64()
-
-		log.Debug("Expression:", expression, "x:", xIncrement-1)
-		result, err := expression.Evaluate(parameters)
-		//fmt.Printf("(%v, %T)\n", result, result)
-
-		if err != nil {
-			log.Error("Formula failed to be evaluate!")
-			return 3
+		if retryOnTimeout, err = getBoolParameter(cmd, cfg, command, "retry_on_timeout", retryOnTimeout, "retry-on-timeout"); err != nil {
+			return backoff.Policy{}, err
+		}
+		if killGracePeriod, err = getIntParameter(cmd, cfg, command, "kill_grace_period", killGracePeriod, "kill-grace-period"); err != nil {
+			return backoff.Policy{}, err
 		}
-		value, _ := result.(float64)
+		retryAfterRegexp = getStringParameter(cmd, cfg, command, "retry_after_regexp", retryAfterRegexp, "retry-after-regexp")
+		if retryAfterOverrides, err = getBoolParameter(cmd, cfg, command, "retry_after_overrides", retryAfterOverrides, "retry-after-overrides"); err != nil {
+			return backoff.Policy{}, err
+		}
+		if explain, err = getBoolParameter(cmd, cfg, command, "explain", explain, "explain"); err != nil {
+			return backoff.Policy{}, err
+		}
+		matchWindow = getStringParameter(cmd, cfg, command, "match_window", matchWindow, "match-window")
+	}
 
-		log.Debug("Formula calculation:", value)
+	// The expression wins over a strategy set in the same place, but a
+	// strategy on the command line still replaces an expression from the
+	// INI file, as would a strategy in the local section over a global
+	// expression
+	if strategy != "" && parameterLevel(cmd, cfg, command, "strategy", "strategy") > parameterLevel(cmd, cfg, command, "expression", "expression") {
+		expression = ""
+	}
 
-		log.Info("Program exitted with exit code: ", cmd.ProcessState.ExitCode())
-		//time.Duration will round to whatever it is multiplied by... do not switch to time.Second
-		sleepForD := time.Duration(value*1000) * time.Millisecond
-		log.Debug("Planning to sleep for", sleepForD)
+	policy := backoff.NewPolicy()
+	policy.Expression = expression
+	if policy.Strategy.Name, err = backoff.ParseStrategy(strategy); err != nil {
+		return policy, err
+	}
+	policy.Strategy.Base = base
+	policy.Strategy.Factor = factor
+	policy.Strategy.Cap = backoffCap
+	policy.Strategy.Min = backoffMin
+	if cmd.Flags().Changed("seed") {
+		policy.Random = rand.NewSource(_seed)
+	}
+	policy.Retries = retries
+	policy.Duration = time.Duration(duration) * time.Second
+	policy.DurationStopsAttempt = durationStopsAttempt
+	policy.RetryOnAll = retryOnAll
+	policy.PerformOnFailure = performOnFailure
+	policy.PerformOnExit = performOnExit
+	policy.PrintRetryOnFailure = printRetryOnFailure
+	policy.PrintVerboseRetryOnFailure = printVerboseRetryOnFailure
+	policy.MetricsEnabled = metricsEnabled
+	policy.PrefixOutput = prefixOutput
+	if passthroughStdin {
+		if retries != 0 {
+			return policy, &backoff.ValueError{Setting: "passthrough_stdin", Value: "true", Expected: "retries to be 0, as stdin can only be read once"}
+		}
+		policy.Stdin = os.Stdin
+		policy.PassthroughStdin = true
+	} else if stdinIsRedirected() {
+		policy.Stdin = os.Stdin
+	}
+	if attemptTimeout > 0 {
+		policy.AttemptTimeout = time.Duration(attemptTimeout) * time.Second
+	}
+	policy.RetryOnTimeout = retryOnTimeout
+	policy.KillGracePeriod = time.Duration(killGracePeriod) * time.Second
+	if policy.OutputMode, err = backoff.ParseOutputMode(outputMode); err != nil {
+		return policy, err
+	}
 
-		t = time.Now()
-		elapsed = t.Sub(start)
-		// If our max wait is 600 seconds, we've waited 596, and our next wait duration is 30,
-		// do some math so we don't go over 600 seconds
-		log.Debug("Overrun check:", (elapsed + sleepForD), ">=", time.Duration(duration)*time.Second)
-		if elapsed+sleepForD >= time.Duration(duration)*time.Second && duration != -1 {
-			sleepForD = time.Duration(duration)*time.Second - elapsed
-			log.Debug("Adjusted Sleep Due To Max Overrun:", sleepForD)
-		}
-		log.Info("Time to sleeping for before retrying: ", sleepForD)
+	// Treat each list as a row from a CSV file so we don't need to do intelligent parsing
+	log.Debug("Converting retryOnExitCodes...")
+	if policy.RetryOnExitCodes, err = backoff.ParseExitCodes(retryOnExitCodes); err != nil {
+		return policy, err
+	}
+	log.Debug("Converting retryOnSignals...")
+	if policy.RetryOnSignals, err = backoff.ParseSignals(retryOnSignals); err != nil {
+		return policy, err
+	}
+	log.Debug("Converting successOnExitCodes...")
+	if policy.SuccessOnExitCodes, err = backoff.ParseExitCodes(successOnExitCodes); err != nil {
+		return policy, err
+	}
 
-		if (printRetryOnFailure || printVerboseRetryOnFailure) {
-			if (printVerboseRetryOnFailure) {
-				os.Stderr.WriteString(stderr.String())
-				fmt.Print(out.String())
-			}
-			fmt.Println("Next Retry Attempt",xIncrement,"in",sleepForD,"...")
-		}
-		time.Sleep(sleepForD)
+	log.Debug("Converting retryOnStringMatches...")
+	if policy.RetryOnStrings, err = backoff.ParseStringMatchers(retryOnStringMatches); err != nil {
+		return policy, err
+	}
+	log.Debug("Converting successOnStringMatches...")
+	if policy.SuccessOnStrings, err = backoff.ParseStringMatchers(successOnStringMatches); err != nil {
+		return policy, err
+	}
 
-		if performOnFailure != "" {
-			catchFailure("Failure",performOnFailure)			
-		}
+	log.Debug("Converting retryOnRegexpMatches...")
+	if policy.RetryOnRegexps, err = backoff.ParseRegexpMatchers(retryOnRegexpMatches); err != nil {
+		return policy, err
+	}
+	log.Debug("Converting successOnRegexpMatches...")
+	if policy.SuccessOnRegexps, err = backoff.ParseRegexpMatchers(successOnRegexpMatches); err != nil {
+		return policy, err
 	}
-}
 
-func catchFailure(typeOfCatch string, command string) {
-	log.Debug("Parsing:", command)
-	errorCommand, err := shellwords.Parse(command)
-	if err != nil {
-		log.Error("Unable to parse Perform On ",typeOfCatch," command input:", command)
-		log.Critical(err)
-		os.Exit(1)
-	}
-
-	log.Debug("Perform On ",typeOfCatch," Command string parsed as:\n")
-	for _, field := range errorCommand {
-		log.Debug(field)
-	}
-
-	log.Debug("Running:", errorCommand[0])
-	log.Debug("Params:", errorCommand[1:len(errorCommand)])
-	ecmd := exec.Command(errorCommand[0], errorCommand[1:len(errorCommand)]...)
-	var eout bytes.Buffer
-	var estderr bytes.Buffer
-	ecmd.Stdout = &eout
-	ecmd.Stderr = &estderr
-	ecmd.Run()
-	eexitCode := ecmd.ProcessState.ExitCode()
-	log.Debug("Command exited with ", eexitCode)
-	log.Info(estderr.String())
-	log.Info(eout.String())
-	if eexitCode != 0 {
-		log.Error("Unable To Run Perform On ",typeOfCatch," command:", command)
-		log.Critical(err)
-		os.Exit(1)
+	log.Debug("Converting retryOnJSONMatches...")
+	if policy.RetryOnJSON, err = backoff.ParseJSONMatchers(retryOnJSONMatches); err != nil {
+		return policy, err
 	}
-}
+	log.Debug("Converting successOnJSONMatches...")
+	if policy.SuccessOnJSON, err = backoff.ParseJSONMatchers(successOnJSONMatches); err != nil {
+		return policy, err
+	}
+	log.Debug("Converting failOnJSONMatches...")
+	if policy.FailOnJSON, err = backoff.ParseJSONMatchers(failOnJSONMatches); err != nil {
+		return policy, err
+	}
+	policy.RetryIf = retryIf
+	policy.SucceedIf = succeedIf
+	policy.FailIf = failIf
 
-func logMetric(startTime string, endTime string, elapsedTime string, command string, args string, result string, output string, stdErr string) {
-	if ! _metricsEnabled {
-		log.Debug("Metrics disabled")
-		return
+	log.Debug("Converting failOnStringMatches...")
+	if policy.FailOnStrings, err = backoff.ParseStringMatchers(failOnStringMatches); err != nil {
+		return policy, err
+	}
+	log.Debug("Converting failOnRegexpMatches...")
+	if policy.FailOnRegexps, err = backoff.ParseRegexpMatchers(failOnRegexpMatches); err != nil {
+		return policy, err
 	}
-	csvName := "eb-metrics.csv"
-	headers := []string{"startTime", "endTime", "elapsedTime", "command", "args", "result", "output", "stdErr"}
-	data := []string{startTime, endTime, elapsedTime, command, args, result, output, stdErr}
 
-	info, err := os.Stat(csvName)
+	log.Debug("Converting retryAfterRegexp...")
+	if policy.RetryAfterRegexps, err = backoff.ParseRegexps(retryAfterRegexp); err != nil {
+		return policy, err
+	}
+	policy.RetryAfterOverrides = retryAfterOverrides
+	policy.Explain = explain
+	log.Debug("Converting matchWindow...")
+	if policy.MatchWindow, err = backoff.ParseMatchWindow(matchWindow); err != nil {
+		return policy, err
+	}
 
-	//create new file if it doesn't exist. Insert headers
-    if os.IsNotExist(err) {
-		log.Debug("Creating ",csvName)
-		csvFile, err := os.Create(csvName)
+	// Rules from the command line are tried before those in the INI file
+	log.Debug("Converting rules...")
+	for i, spec := range _rules {
+		rule, err := backoff.ParseRuleSpec(spec)
 		if err != nil {
-			log.Error("Unable To Log Metrics! Unable To Create eb-metrics.csv!")
-			log.Error(err)
-			_metricsEnabled = false
-			return
+			return policy, err
 		}
+		if rule.Name == "" {
+			rule.Name = strconv.Itoa(i + 1)
+		}
+		policy.Rules = append(policy.Rules, rule)
+	}
+	if cfg != nil {
+		for _, section := range cfg.Sections() {
+			name, ok := strings.CutPrefix(section.Name(), command+":")
+			if !ok {
+				continue
+			}
+			rule, err := backoff.ParseRule(name, section.KeysHash())
+			if err != nil {
+				return policy, err
+			}
+			policy.Rules = append(policy.Rules, rule)
+		}
+	}
 
-		w := csv.NewWriter(csvFile)
-		w.Write(headers)
-		w.Flush()
-		csvFile.Close()
-		log.Debug("Created ",csvName)
-
-	} else if err != nil {
-		log.Error("Unable To Log Metrics!")
-		log.Error(err)
-		_metricsEnabled = false
-		return
-	} else if info.IsDir() {
-		// Error if the file we are supposed to write to is a directory
-		log.Error("Unable To Log Metrics! eb-metrics.csv is a directory!")
-		log.Error(err)
-		_metricsEnabled = false
-		return
+	log.Debug("Converting failUnlessStringMatches...")
+	if policy.FailUnlessStrings, err = backoff.ParseStringMatchers(failUnlessStringMatches); err != nil {
+		return policy, err
+	}
+	log.Debug("Converting failUnlessRegexpMatches...")
+	if policy.FailUnlessRegexps, err = backoff.ParseRegexpMatchers(failUnlessRegexpMatches); err != nil {
+		return policy, err
 	}
 
-	log.Debug("Logging metrics ",data)
-	csvFile, err := os.OpenFile(csvName, os.O_APPEND|os.O_RDWR, 0666)
-	if err != nil {
-		log.Error("Unable To Data Write To Log!")
-		log.Error(err)
-		_metricsEnabled = false
-		return
-	}
-	w := csv.NewWriter(csvFile) // Create a new to the file stream
-	w.Write(data)
-	w.Flush()
-	csvFile.Close()
+	return policy, nil
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -767,33 +736,61 @@ func logMetric(startTime string, endTime string, elapsedTime string, command str
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
-		os.Exit(1)
+		os.Exit(backoff.InternalErrorExitCode)
 	}
 }
 
 func init() {
-	// eb is a root command with no sub-commands, so everything is global and persistant
+	// eb runs the command it is given, and the only sub-command, schedule,
+	// takes the same settings, so everything is global and persistant
 	// use hyphens instead of camelCase because that is what curl does
+	// Commands are the arguments to eb, so don't take over "completion"
+	rootCmd.CompletionOptions.DisableDefaultCmd = true
 	rootCmd.PersistentFlags().StringVarP(&_iniFile, "ini-file", "f", "", "An INI file to load with tool settings (default $HOME/.eb.ini)\nThe INI file supports global and local parameters\nLocal parameters override global parameters")
-	rootCmd.PersistentFlags().StringVarP(&_expression, "expression", "e", "0", "A mathmematical expression representing the time to wait on each retry\nThe variable 'x' is the current iteration (0 based)\nThe variable 'i' is the current iteration (1 based)\nThe variable 'r' is a random float from 0-1\nExamples: \"x*15+15\", \"x*x\", \"(x*x)+(10*r)\"")
+	rootCmd.PersistentFlags().StringVarP(&_expression, "expression", "e", "0", "A mathmematical expression representing the time to wait on each retry\nThe variable 'x' is the current iteration (0 based)\nThe variable 'i' is the current iteration (1 based)\nThe variable 'r' is a random float from 0-1\nThe variable 'elapsed' is the seconds since the first attempt started\nThe variable 'last_duration' is the seconds the last attempt ran for\nThe variable 'exit_code' is the exit code of the last attempt\nThe variable 'remaining' is the seconds left in the duration (-1 for unlimited)\nThe variable 'retries' is the maximum number of retries (-1 for unlimited)\nThe variable 'prev_sleep' is the seconds waited before the last attempt\nThe variable 'hint' is the seconds asked for by --retry-after-regexp (-1 for no hint)\nThe functions min, max, pow, exp, floor, ceil and rand(a, b) are available\nExamples: \"x*15+15\", \"x*x\", \"(x*x)+(10*r)\", \"min(300, pow(2, x)) + rand(0, 5)\"")
+	rootCmd.PersistentFlags().StringVar(&_strategy, "strategy", "", "A built in backoff strategy to use instead of an expression\nOne of constant, linear, exponential, full-jitter, equal-jitter,\ndecorrelated-jitter or fibonacci. --expression wins when both are set")
+	rootCmd.PersistentFlags().Float64Var(&_base, "base", 0, "The seconds a strategy waits before the first retry (default 1)")
+	rootCmd.PersistentFlags().Float64Var(&_factor, "factor", 0, "How quickly a strategy's wait grows\n(default --base for linear, 3 for decorrelated-jitter, otherwise 2)")
+	rootCmd.PersistentFlags().Float64Var(&_cap, "cap", 0, "The longest a strategy waits, in seconds, before jitter (default no cap)")
+	rootCmd.PersistentFlags().Float64Var(&_min, "min", 0, "The shortest a strategy waits, in seconds")
 	rootCmd.PersistentFlags().IntVarP(&_retries, "retries", "r", -1, "The number of times to retry the command")
 	rootCmd.PersistentFlags().IntVarP(&_duration, "duration", "d", -1, "How many seconds to keep retrying")
+	rootCmd.PersistentFlags().BoolVar(&_durationStopsAttempt, "duration-stops-attempt", false, "Stop an attempt that is still running when --duration runs out")
+	rootCmd.PersistentFlags().IntVar(&_attemptTimeout, "attempt-timeout", -1, "How many seconds a single attempt may run for before it is stopped")
+	rootCmd.PersistentFlags().BoolVar(&_retryOnTimeout, "retry-on-timeout", false, "Retry attempts stopped by --attempt-timeout")
+	rootCmd.PersistentFlags().IntVar(&_killGracePeriod, "kill-grace-period", 10, "How many seconds a command is given to exit after SIGTERM before it is killed")
+	rootCmd.PersistentFlags().StringArrayVar(&_rules, "rule", nil, "A rule pairing a matcher with how to handle what it matches, as key=value pairs\nsuch as 'name=quota string_matches=\"Quota exceeded\" expression=100 retries=10'\nThe keys are name, exit_codes, string_matches, regexp_matches, json_matches,\nsignals, action (retry, succeed, fail, continue or fail-continue), unless,\nonly_on_failure, on_success, retry_if, succeed_if, fail_if, expression and retries.\nRetry rules only match failures, unless on_success=true\nRules are tried in order, before the other matchers, and the first to match\ndecides. May be given more than once")
+	rootCmd.PersistentFlags().StringVar(&_matchWindow, "match-window", "", "Only match against the end of stdout and stderr, such as \"200 lines\" or \"65536 bytes\"\nBoth may be given, comma delimited (default all output)")
+	rootCmd.PersistentFlags().BoolVar(&_explain, "explain", false, "Print the rules an attempt is matched against, in order, and which rule decided each attempt")
+	rootCmd.PersistentFlags().StringVar(&_retryAfterRegexp, "retry-after-regexp", "", "A comma delimited list of regular expressions that read how long to wait before retrying\nfrom stderr or stdout, such as \"Retry-After: (\\S+)\". The first capture group is read as\nseconds, a duration such as 1m30s, or an HTTP date. The wait is at least that long")
+	rootCmd.PersistentFlags().BoolVar(&_retryAfterOverrides, "retry-after-overrides", false, "Wait for as long as --retry-after-regexp asks, rather than for at least that long")
 	rootCmd.PersistentFlags().BoolVarP(&_retryOnAll, "retry-on-all", "a", false, "Retry on all non-zero exit codes")
 	rootCmd.PersistentFlags().BoolVarP(&_metricsEnabled, "enable-metrics", "b", false, "Enable collection of call metrics")
 	rootCmd.PersistentFlags().StringVarP(&_performOnFailure, "perform-on-failure", "p", "", "A command to run prior to retrying the command. Useful for cleanup")
 	rootCmd.PersistentFlags().StringVarP(&_performOnExit, "perform-on-exit", "P", "", "A command to run prior to exiting. Useful for uploading metrics")
+	rootCmd.PersistentFlags().StringVar(&_outputMode, "output-mode", "buffered", "When to show the output of the command\n\"buffered\" shows the output of the final attempt once it is done\n\"streamed\" shows the output of every attempt as it is produced")
+	rootCmd.PersistentFlags().BoolVar(&_prefixOutput, "prefix-output", false, "Prefix each line of streamed output with the attempt number")
+	rootCmd.PersistentFlags().BoolVar(&_passthroughStdin, "passthrough-stdin", false, "Give the command eb's stdin as is, so it can be used interactively\nRequires --retries 0, as stdin can only be read once")
 	rootCmd.PersistentFlags().BoolVarP(&_printRetryOnFailure, "print-retry-on-failure", "m", false, "Print a simple retrying message prior to retrying")
 	rootCmd.PersistentFlags().BoolVarP(&_printVerboseRetryOnFailure, "print-verbose-retry-on-failure", "M", false, "Print a verboose retrying message prior to retrying")
 	rootCmd.PersistentFlags().StringVarP(&_retryOnExitCodes, "retry-on-exit-codes", "c", "", "A comma delimited list of exit codes to try on")
+	rootCmd.PersistentFlags().StringVar(&_retryOnSignals, "retry-on-signals", "", "A comma delimited list of signals to retry on when they kill the command, such as \"SIGKILL,SIGSEGV\"")
 	rootCmd.PersistentFlags().StringVarP(&_retryOnStringMatches, "retry-on-string-matches", "s", "", "A comma delimited list of strings found in stderr or stdout to retry on")
 	rootCmd.PersistentFlags().StringVarP(&_retryOnRegexpMatches, "retry-on-regexp-matches", "x", "", "A comma delimited list of regular expressions found in stderr or stdout to retry on")
+	rootCmd.PersistentFlags().StringVar(&_retryOnJSONMatches, "retry-on-json-matches", "", "A comma delimited list of conditions on JSON in stderr or stdout to retry on, such as \"error.code == 429\"")
+	rootCmd.PersistentFlags().StringVar(&_retryIf, "retry-if", "", "Retry a failed attempt when this expression is true, such as\n\"exit_code == 1 && duration < 2\". The variables are exit_code, duration,\nattempt, stdout_lines and stderr_lines, and the functions stdout_contains,\nstderr_contains, stdout_matches and stderr_matches")
 	rootCmd.PersistentFlags().StringVarP(&_successOnExitCodes, "success-on-exit-codes", "C", "", "A comma delimited list of exit codes to change to success codes")
 	rootCmd.PersistentFlags().StringVarP(&_successOnStringMatches, "success-on-string-matches", "S", "", "A comma delimited list of strings to change to success codes")
 	rootCmd.PersistentFlags().StringVarP(&_successOnRegexpMatches, "success-on-regexp-matches", "X", "", "A comma delimited list of regular expressions to change to success codes")
+	rootCmd.PersistentFlags().StringVar(&_successOnJSONMatches, "success-on-json-matches", "", "A comma delimited list of conditions on JSON in stderr or stdout to change to success codes")
+	rootCmd.PersistentFlags().StringVar(&_succeedIf, "succeed-if", "", "Treat the command as successful when this expression is true, as --retry-if")
 	rootCmd.PersistentFlags().StringVarP(&_failOnStringMatches, "fail-on-string-matches", "o", "", "A comma delimited list of strings to consider failures to retry on")
 	rootCmd.PersistentFlags().StringVarP(&_failOnRegexpMatches, "fail-on-regexp-matches", "O", "", "A comma delimited list of regular expressions to consider failures to retry on")
+	rootCmd.PersistentFlags().StringVar(&_failOnJSONMatches, "fail-on-json-matches", "", "A comma delimited list of conditions on JSON in stderr or stdout to consider failures to retry on")
+	rootCmd.PersistentFlags().StringVar(&_failIf, "fail-if", "", "Fail the command without retrying when this expression is true, as --retry-if")
 	rootCmd.PersistentFlags().StringVarP(&_failUnlessStringMatches, "fail-unless-string-matches", "u", "", "A comma delimited list of strings consider successful. Fail otherwise")
 	rootCmd.PersistentFlags().StringVarP(&_failUnlessRegexpMatches, "fail-unless-regexp-matches", "U", "", "A comma delimited list of regular expressions to consider successful. Fail otherwise")
+	rootCmd.PersistentFlags().Int64Var(&_seed, "seed", 0, "Seed the randomness in the expression, and in --kill, so it is the same every time (default random)")
 	rootCmd.PersistentFlags().BoolVarP(&_verbose, "verbose", "v", false, "Enable verbose output")
 	rootCmd.PersistentFlags().BoolVar(&_version, "version", false, "Print the version and exit")
 	rootCmd.PersistentFlags().BoolVarP(&_kill, "kill", "k", false, "Immediately exit with a .75 probability (for testing failures)")
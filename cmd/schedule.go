@@ -0,0 +1,127 @@
+/*
+* Copyright 2020-present, Synopsys, Inc. * All rights reserved.
+*
+* This source code is licensed under the Apache-2.0 license found in
+* the LICENSE file in the root directory of this source tree. */
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/blackducksoftware/exponential-backoff-tool/backoff"
+	"github.com/spf13/cobra"
+)
+
+// The most retries shown when neither retries nor duration limit them
+const scheduleLimit = 100
+
+var _samples int
+
+var scheduleCmd = &cobra.Command{
+	Use:   "schedule [command]",
+	Short: "Preview the waits between retries without running anything",
+	Long: `Preview the waits between retries without running anything
+
+Prints how long eb would wait before each attempt, and the total time
+waited, assuming every attempt fails straight away. The same flags and
+INI file settings as running a command are used. Give the name of a
+command to use its section of the INI file.
+
+Use --seed to get the same jitter every time, and --samples to see how
+much the total wait varies from run to run.`,
+	Args: cobra.MaximumNArgs(1),
+
+	Run: func(cmd *cobra.Command, args []string) {
+		configureLogging(_verbose, _debug)
+		command := ""
+		if len(args) == 1 {
+			command = args[0]
+		}
+		policy, err := loadParameters(cmd, command, _iniFile)
+		if err != nil {
+			exitOnError(err)
+		}
+		seed := _seed
+		if !cmd.Flags().Changed("seed") {
+			seed = time.Now().UnixNano()
+		}
+
+		if _samples > 0 {
+			err = printSamples(policy, seed, _samples)
+		} else {
+			var schedule backoff.Schedule
+			if schedule, err = backoff.Preview(policy, seed, scheduleLimit); err == nil {
+				printSchedule(schedule)
+			}
+		}
+		if err != nil {
+			exitOnError(err)
+		}
+	},
+}
+
+func printSchedule(schedule backoff.Schedule) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ATTEMPT\tSLEEP\tELAPSED\t")
+	fmt.Fprintln(w, "1\t-\t0s\t")
+	for _, step := range schedule.Steps {
+		note := ""
+		if step.Clamped() {
+			note = fmt.Sprintf("clamped by duration from %s", roundDuration(step.Unclamped))
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", step.Attempt, roundDuration(step.Sleep), roundDuration(step.Elapsed), note)
+	}
+	w.Flush()
+
+	attempts := len(schedule.Steps) + 1
+	if schedule.Truncated {
+		fmt.Printf("Showing the first %d attempts. Retrying carries on after that.\n", attempts)
+		return
+	}
+	fmt.Printf("Stops after %d attempts (%s), having waited %s.\n", attempts, schedule.Reason, roundDuration(schedule.Total()))
+}
+
+// printSamples works out the schedule samples times, with a different seed
+// each time, and prints the spread of the total time waited
+func printSamples(policy backoff.Policy, seed int64, samples int) error {
+	totals := make([]time.Duration, samples)
+	for i := range totals {
+		schedule, err := backoff.Preview(policy, seed+int64(i), scheduleLimit)
+		if err != nil {
+			return err
+		}
+		totals[i] = schedule.Total()
+	}
+	sort.Slice(totals, func(i, j int) bool { return totals[i] < totals[j] })
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SAMPLES\tMIN\tMEDIAN\tP95\tMAX")
+	fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", samples,
+		roundDuration(totals[0]),
+		roundDuration(percentile(totals, 50)),
+		roundDuration(percentile(totals, 95)),
+		roundDuration(totals[samples-1]))
+	return w.Flush()
+}
+
+// percentile uses the nearest rank method on sorted durations
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	return d.Round(time.Millisecond)
+}
+
+func init() {
+	scheduleCmd.Flags().IntVar(&_samples, "samples", 0, "Work out the schedule this many times and show the min, median and 95th percentile of the total wait")
+	rootCmd.AddCommand(scheduleCmd)
+}